@@ -1,20 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
-	"io"
 	"log"
-	"net"
-	"net/netip"
 	"os"
 	"strconv"
 	"time"
 
+	"powquote/internal/client"
 	"powquote/internal/protocol"
 	"powquote/internal/puzzle"
+	"powquote/internal/tlsutil"
 )
 
-var ioTimeout = time.Second * 10
+var benchmarkDuration = time.Millisecond * 200
+
+var retryBackoff = time.Second
 
 func main() {
 	verboseVar := os.Getenv("VERBOSE")
@@ -28,93 +32,77 @@ func main() {
 		log.Fatal("SERVER variable must point to a server")
 	}
 
-	clientID, serverID, err := getIPs(serverAddr)
+	limits, err := limitsFromEnv()
 	if err != nil {
-		log.Fatalf("unable to detect client id: %v", err)
+		log.Fatal(err)
+	}
+
+	c := client.New(serverAddr)
+	c.Limits = limits
+	switch network := os.Getenv("NETWORK"); network {
+	case "", "tcp", "udp", "unix":
+		c.Network = network
+	default:
+		log.Fatal("NETWORK variable is set but incorrect; should be tcp, udp or unix")
+	}
+	if useTLS, _ := strconv.ParseBool(os.Getenv("TLS")); useTLS {
+		c.TLS, err = tlsutil.ClientConfig(tlsutil.ClientOptions{
+			CAFile:     os.Getenv("TLS_CA"),
+			ServerName: os.Getenv("TLS_SERVER_NAME"),
+			CertFile:   os.Getenv("TLS_CERT"),
+			KeyFile:    os.Getenv("TLS_KEY"),
+		})
+		if err != nil {
+			log.Fatalf("error configuring TLS: %v", err)
+		}
+	}
+	if serverKeyVar := os.Getenv("SERVER_KEY"); serverKeyVar != "" {
+		key, err := base64.StdEncoding.DecodeString(serverKeyVar)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			log.Fatal("SERVER_KEY variable is set but incorrect; should be base64 ed25519 public key")
+		}
+		c.ServerKey = key
+	}
+	if retriesVar := os.Getenv("RETRIES"); retriesVar != "" {
+		retries, err := strconv.Atoi(retriesVar)
+		if err != nil {
+			log.Fatal("RETRIES variable is set but incorrect; should be integer")
+		}
+		c.Retry = client.RetryPolicy{Attempts: retries + 1, Backoff: retryBackoff}
 	}
-
-	challengeBs, err := say(serverAddr, protocol.Hello)
-	if err != nil {
-		log.Fatalf("error saying to server: %v", err)
-	}
-
-	challenge, err := protocol.ChallengeFromBytes(challengeBs)
-	if err != nil {
-		log.Fatalf("error parsing challenge: %v", err)
-	}
-
 	if verbose {
-		log.Println("solving challenge from server:", challenge)
+		c.Logf = log.Printf
+		c.OnProgress = func(challenge protocol.Challenge, p puzzle.Progress) {
+			log.Printf("solving: %v", p)
+		}
 	}
 
-	clientNonce := puzzle.GenerateNonceOnce()
-
-	hashData := protocol.HashData{
-		ClientID:    clientID,
-		NonceServer: challenge.Nonce,
-		NonceClient: clientNonce,
-	}
-
-	goodhash := puzzle.Solve(&hashData, challenge)
-	if verbose {
-		log.Printf("found solution: %v", goodhash)
-	}
-
-	quoteReq := protocol.QuoteRequest{
-		ServerID: serverID,
-		HashData: hashData,
-	}
-	if verbose {
-		log.Printf("making quote request: %q", quoteReq.Bytes())
-	}
-	quote, err := say(serverAddr, quoteReq.Bytes())
+	quote, err := c.FetchQuote(context.Background())
 	if err != nil {
-		log.Fatalf("error sending solution: %v", err)
+		log.Fatal(err)
 	}
 
 	if verbose {
-		log.Printf("(👉ﾟヮﾟ)👉 %s", quote)
+		log.Printf("(👉ﾟヮﾟ)👉 %s", quote.Text)
 	} else {
-		fmt.Printf("%s", quote)
+		fmt.Printf("%s", quote.Text)
 	}
 }
 
-func getIPs(serverAddr string) (string, string, error) {
-	conn, err := net.Dial("tcp", serverAddr)
-	if err != nil {
-		return "", "", err
-	}
-	defer func(){
-		_ = conn.Close()
-	}()
-
-	locAddr, err := netip.ParseAddrPort(conn.LocalAddr().String())
-	if err != nil {
-		return "", "", err
-	}
-	return locAddr.Addr().String(), conn.RemoteAddr().String(), nil
-}
-
-func say(serverAddr string, what []byte) ([]byte, error) {
-	conn, err := net.Dial("tcp", serverAddr)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-	if err := conn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
-		return nil, err
-	}
-
-	what = append(what, '\n')
-	if _, err := conn.Write(what); err != nil {
-		return nil, err
-	}
-
-	bs, err := io.ReadAll(conn)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return bs, nil
+func limitsFromEnv() (limits puzzle.Limits, err error) {
+	if maxComplexityVar := os.Getenv("MAX_COMPLEXITY"); maxComplexityVar != "" {
+		val, err := strconv.ParseInt(maxComplexityVar, 10, 32)
+		if err != nil {
+			return limits, fmt.Errorf("MAX_COMPLEXITY variable is set but incorrect; should be integer")
+		}
+		limits.MaxComplexity = int(val)
+	}
+	if maxSolveTimeVar := os.Getenv("MAX_SOLVE_TIME"); maxSolveTimeVar != "" {
+		limits.MaxDuration, err = time.ParseDuration(maxSolveTimeVar)
+		if err != nil {
+			return limits, fmt.Errorf("MAX_SOLVE_TIME variable is set but incorrect; should be duration like 30s")
+		}
+		limits.HashRate = puzzle.Benchmark(benchmarkDuration)
+	}
+	return limits, nil
 }
@@ -2,110 +2,218 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"flag"
 	"log"
-	"net"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"powquote/internal/protocol"
+	"powquote/internal/ban"
+	"powquote/internal/config"
+	"powquote/internal/listen"
+	"powquote/internal/mtls"
 	"powquote/internal/puzzle"
-	"powquote/internal/quotes"
+	"powquote/internal/server"
+	"powquote/internal/tlsutil"
 )
 
-var nonces = puzzle.NewNonceGenerator(time.Minute * 5)
+// bansSavePeriod is how often the bans are saved to bans_file
+const bansSavePeriod = time.Second * 30
 
-var complexity = 5
-
-var ioTimeout = time.Second * 30
-
-func init() {
-	if complexityVar := os.Getenv("COMPLEXITY"); complexityVar != "" {
-		if val, err := strconv.ParseInt(complexityVar, 10, 32); err != nil {
-			panic("COMPLEXITY variable is set but incorrect; should be integer")
-		} else {
-			complexity = int(val)
+func main() {
+	loader, err := config.NewLoader(os.Args[0], os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if loader.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
 		}
+		return
 	}
-}
 
-func main() {
-	listen := os.Getenv("LISTEN")
-	if listen == "" {
-		panic("invalid LISTEN variable")
+	listeners, err := server.ParseListeners(strings.Join(cfg.Listeners, ","))
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	ln, err := net.Listen("tcp", listen)
+	inherited, err := listen.Systemd()
 	if err != nil {
-		panic(err)
+		log.Fatalf("error using sockets passed by systemd: %v", err)
+	}
+	if cfg.Listen == "" && cfg.HTTPListen == "" && cfg.UDPListen == "" && len(listeners) == 0 && len(inherited) == 0 {
+		log.Fatal("no listeners; set any of listen, http_listen, udp_listen and listeners, or use systemd socket activation")
 	}
 
-	rootctx := context.Background()
+	var proxy *server.Proxy
+	if cfg.Upstream != "" {
+		proxy = server.NewProxy(cfg.Upstream)
+		proxy.IdleTimeout = time.Duration(cfg.UpstreamIdleTimeout)
+	}
+	reloader := newReloader(loader, cfg, proxy)
+	opts, err := reloader.options(cfg, reloader.limiter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts,
+		server.WithDrainTimeout(time.Duration(cfg.DrainTimeout)),
+		server.WithMaxConnections(cfg.MaxConnections),
+		server.WithSilentRejects(cfg.SilentRejects),
+		server.WithRequestTimeout(time.Duration(cfg.RequestTimeout)),
+	)
+	if proxy != nil {
+		opts = append(opts, server.WithSingleConnection())
+	}
 
-	go nonces.Start(rootctx)
+	tlsEnabled := false
 
-	log.Printf("begin listening on %v; DoS protected = %v, complexity = %v", ln.Addr(), puzzle.ProtectionEnabled(), complexity)
+	// validated with the configuration
+	proxyTrusted, _ := cfg.ProxyTrustedPrefixes()
+	switch cfg.ProxyProtocol {
+	case "on":
+		opts = append(opts, server.WithProxyProtocol(server.ProxyProtocol{Trusted: proxyTrusted}))
+	case "optional":
+		opts = append(opts, server.WithProxyProtocol(server.ProxyProtocol{Optional: true, Trusted: proxyTrusted}))
+	}
 
-	for {
-		conn, err := ln.Accept()
-		if err := conn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
-			log.Printf("error setting deadline: %v", err)
-		}
+	if cfg.TLSCert != "" {
+		certs, err := tlsutil.NewCertReloader(cfg.TLSCert, cfg.TLSKey)
 		if err != nil {
-			log.Printf("error accepting connection: %v", err)
+			log.Fatalf("error loading tls_cert and tls_key: %v", err)
 		}
-		go handleConnection(conn)
+		certs.OnError = func(err error) {
+			log.Printf("error reloading TLS certificate, keeping the previous one: %v", err)
+		}
+		var clientCAs *x509.CertPool
+		if cfg.TLSClientCA != "" {
+			if clientCAs, err = tlsutil.LoadCertPool(cfg.TLSClientCA); err != nil {
+				log.Fatalf("error loading tls_client_ca: %v", err)
+			}
+		}
+		opts = append(opts, server.WithTLS(tlsutil.ServerConfig(certs, clientCAs)))
+		tlsEnabled = true
 	}
-}
 
-func handleConnection(conn net.Conn) {
-	defer func() {
-		addr := conn.RemoteAddr()
-		if err := conn.Close(); err != nil {
-			log.Printf("(%v) error closing connection: %v", addr, err)
-			return
+	if cfg.ClientPolicyFile != "" {
+		policies, err := mtls.Load(cfg.ClientPolicyFile)
+		if err != nil {
+			log.Fatalf("error loading client_policy_file: %v", err)
 		}
-		log.Printf("(%v) connection closed by server", addr)
-	}()
-
-	log.Printf("(%v) connected", conn.RemoteAddr())
+		opts = append(opts, server.WithClientPolicies(policies))
+	}
 
-	if !puzzle.ProtectionEnabled() {
-		writeResponse(conn, []byte(quotes.Next()))
-		return
+	if cfg.IdentityKeyFile != "" {
+		key, err := loadIdentityKey(cfg.IdentityKeyFile)
+		if err != nil {
+			log.Fatalf("error loading identity_key_file: %v", err)
+		}
+		opts = append(opts, server.WithIdentityKey(key))
 	}
 
-	req, err := puzzle.ReadRequest(conn)
-	if err != nil {
-		log.Printf("(%v) error processing request: %v", conn.RemoteAddr(), err)
-		writeResponse(conn, []byte("send hello request to begin client puzzle"))
-		return
+	if len(cfg.ServerID) > 0 {
+		opts = append(opts, server.WithServerIDs(cfg.ServerID...))
 	}
 
-	challenge := protocol.Challenge{
-		Nonce:      nonces.Current(),
-		Complexity: complexity,
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.BanStrikes > 0 {
+		banPolicy := ban.DefaultPolicy
+		banPolicy.Strikes = cfg.BanStrikes
+		banPolicy.Duration = time.Duration(cfg.BanDuration)
+		bans := ban.NewManager(banPolicy)
+		bansFile := cfg.BansFile
+		if bansFile != "" {
+			if err := bans.LoadFile(bansFile); err != nil {
+				log.Fatalf("error loading bans_file: %v", err)
+			}
+		}
+		bansSaved := make(chan struct{})
+		go func() {
+			defer close(bansSaved)
+			bans.Run(ctx, bansFile, bansSavePeriod)
+		}()
+		defer func() {
+			<-bansSaved
+		}()
+		go logBansOnSignal(ctx, bans)
+		opts = append(opts, server.WithBans(bans))
 	}
 
-	switch req := req.(type) {
-	case protocol.ChallengeRequest:
-		log.Printf("(%v) challenge request", conn.RemoteAddr())
-		writeResponse(conn, challenge.Bytes())
-	case protocol.QuoteRequest:
-		log.Printf("(%v) quote request", conn.RemoteAddr())
-		if err := puzzle.SolutionValid(challenge, conn.LocalAddr(), conn.RemoteAddr(), req); err == nil {
-			log.Printf("(%v) solution correct %v", conn.RemoteAddr(), puzzle.Hash(&req.HashData))
-			writeResponse(conn, []byte(quotes.Next()))
-		} else {
-			log.Printf("(%v) invalid solution: %v", conn.RemoteAddr(), err)
-			writeResponse(conn, []byte("invalid solution"))
+	srv := server.New(cfg.Listen, opts...)
+	log.Printf("challenges are signed with key %s (%v)", base64.StdEncoding.EncodeToString(srv.PublicKey()), puzzle.KeyFingerprint(srv.PublicKey()))
+	reloader.srv = srv
+	go reloader.run(ctx)
+
+	// listen, http_listen and inherited stream sockets use TLS when tls_cert is set
+	streamScheme, httpScheme := "tcp", "http"
+	if tlsEnabled {
+		streamScheme, httpScheme = "tls", "https"
+	}
+	if cfg.Listen != "" {
+		listeners = append(listeners, server.Listener{Scheme: streamScheme, Addr: cfg.Listen})
+	}
+	if cfg.HTTPListen != "" {
+		listeners = append(listeners, server.Listener{Scheme: httpScheme, Addr: cfg.HTTPListen})
+	}
+	if cfg.UDPListen != "" {
+		listeners = append(listeners, server.Listener{Scheme: "udp", Addr: cfg.UDPListen})
+	}
+	if cfg.MetricsListen != "" {
+		listeners = append(listeners, server.Listener{Scheme: "metrics", Addr: cfg.MetricsListen})
+	}
+	// sockets named "http" in the socket unit serve the gateway, other stream sockets the TCP protocol
+	for _, sock := range inherited {
+		switch {
+		case sock.PacketConn != nil:
+			listeners = append(listeners, server.Listener{Scheme: "udp", PacketConn: sock.PacketConn})
+		case sock.Name == "http":
+			listeners = append(listeners, server.Listener{Scheme: httpScheme, Listener: sock.Listener})
+		default:
+			listeners = append(listeners, server.Listener{Scheme: streamScheme, Listener: sock.Listener})
 		}
 	}
+
+	err = srv.Run(ctx, listeners)
+	for name, stats := range srv.ListenerStats() {
+		log.Printf("listener %v stopped: %v", name, stats)
+	}
+	log.Printf("server stopped: %v", srv.Stats())
+	if proxy != nil {
+		log.Printf("proxy stopped: %v", proxy.Stats())
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-func writeResponse(conn net.Conn, bs []byte) {
-	log.Printf("(%v) writing: %s", conn.RemoteAddr(), bs)
-	if _, err := conn.Write(bs); err != nil {
-		log.Printf("error writing response: %v", err)
+// logBansOnSignal prints currently banned clients on SIGUSR1
+func logBansOnSignal(ctx context.Context, bans *ban.Manager) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			list := bans.List()
+			log.Printf("%v clients banned", len(list))
+			for _, e := range list {
+				log.Printf("banned %v until %v after %v bans", e.Addr, e.Until.Format(time.RFC3339), e.Bans)
+			}
+		}
 	}
 }
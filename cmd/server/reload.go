@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"powquote/internal/acl"
+	"powquote/internal/config"
+	"powquote/internal/quotes"
+	"powquote/internal/ratelimit"
+	"powquote/internal/server"
+)
+
+// fileCheckPeriod is how often the configuration, ACL and quotes files are checked for changes
+const fileCheckPeriod = time.Second * 5
+
+// reloader applies the live settings to the running server on SIGHUP and when the configuration, ACL or quotes file changes
+type reloader struct {
+	loader *config.Loader
+	srv    *server.Server
+	proxy  *server.Proxy
+	// cfg is the configuration in use
+	cfg     config.Config
+	limiter *ratelimit.ClientLimiter
+	// modTimes of the watched files when they were last loaded
+	modTimes map[string]time.Time
+}
+
+func newReloader(loader *config.Loader, cfg config.Config, proxy *server.Proxy) *reloader {
+	r := &reloader{loader: loader, proxy: proxy, cfg: cfg, limiter: ratelimit.NewClientLimiter(rateLimit(cfg))}
+	r.modTimes = r.watched()
+	return r
+}
+
+// options are the live settings of cfg as server options; the ACL and quotes files are read again
+func (r *reloader) options(cfg config.Config, limiter *ratelimit.ClientLimiter) ([]server.Option, error) {
+	level, err := server.ParseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	opts := []server.Option{
+		server.WithProtection(cfg.Protected),
+		server.WithComplexity(cfg.Complexity),
+		server.WithRateLimiter(limiter),
+		server.WithLogLevel(level),
+	}
+
+	var rules *acl.ACL
+	if cfg.ACLFile != "" {
+		if rules, err = acl.Load(cfg.ACLFile); err != nil {
+			return nil, fmt.Errorf("error loading acl_file: %w", err)
+		}
+	}
+	opts = append(opts, server.WithACL(rules))
+
+	switch {
+	case r.proxy != nil:
+		opts = append(opts, server.WithHandler(r.proxy))
+	case cfg.QuotesFile != "":
+		list, err := quotes.Load(cfg.QuotesFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading quotes_file: %w", err)
+		}
+		opts = append(opts, server.WithHandler(server.ListHandler(list)))
+	default:
+		opts = append(opts, server.WithHandler(server.QuoteHandler))
+	}
+	return opts, nil
+}
+
+// reload loads the configuration again and applies the live changes; the running configuration is kept on errors.
+// The files are not loaded again until they change, so a broken file is reported once
+func (r *reloader) reload() {
+	r.modTimes = r.watched()
+	next, err := r.loader.Load()
+	if err != nil {
+		log.Printf("error reloading configuration, keeping the running one: %v", err)
+		return
+	}
+
+	changes := config.Diff(r.cfg, next)
+	limiter := r.limiter
+	for _, c := range changes {
+		switch c.Key {
+		case "rate_limit", "rate_burst", "subnet_rate_limit", "subnet_rate_burst", "ipv4_prefix", "ipv6_prefix":
+			limiter = ratelimit.NewClientLimiter(rateLimit(next))
+		}
+	}
+	opts, err := r.options(next, limiter)
+	if err != nil {
+		log.Printf("error reloading configuration, keeping the running one: %v", err)
+		return
+	}
+	r.srv.Reload(opts...)
+	limitsReset := limiter != r.limiter
+	r.cfg, r.limiter = r.cfg.WithLive(next), limiter
+
+	if limitsReset {
+		log.Printf("configuration reloaded, rate limits start over with full buckets")
+	} else {
+		log.Printf("configuration reloaded")
+	}
+	for _, c := range changes {
+		if c.Live {
+			log.Printf("%v", c)
+		} else {
+			log.Printf("%v, restart the server to apply", c)
+		}
+	}
+}
+
+// run reloads on SIGHUP and on changes of the watched files until ctx is done
+func (r *reloader) run(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	ticker := time.NewTicker(fileCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			r.reload()
+		case <-ticker.C:
+			if r.changed() {
+				r.reload()
+			}
+		}
+	}
+}
+
+// watched returns the modification times of the configuration, ACL and quotes files that exist
+func (r *reloader) watched() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{r.loader.File, r.cfg.ACLFile, r.cfg.QuotesFile} {
+		if path == "" {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil {
+			modTimes[path] = fi.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func (r *reloader) changed() bool {
+	modTimes := r.watched()
+	if len(modTimes) != len(r.modTimes) {
+		return true
+	}
+	for path, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+func rateLimit(cfg config.Config) ratelimit.Config {
+	return ratelimit.Config{
+		PerIP:         ratelimit.Rate{PerSecond: cfg.RateLimit, Burst: cfg.RateBurst},
+		PerSubnet:     ratelimit.Rate{PerSecond: cfg.SubnetRateLimit, Burst: cfg.SubnetRateBurst},
+		IPv4PrefixLen: cfg.IPv4Prefix,
+		IPv6PrefixLen: cfg.IPv6Prefix,
+	}
+}
@@ -0,0 +1,122 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+type Verdict int
+
+const (
+	// None means the address is in neither list and must solve the puzzle as usual
+	None Verdict = iota
+	Allow
+	Deny
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Allow:
+		return "allow"
+	case Deny:
+		return "deny"
+	}
+	return "none"
+}
+
+// ACL is a static allowlist and denylist of IPv4 and IPv6 ranges
+type ACL struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// Parse reads rules in the form of "allow <cidr or ip>" or "deny <cidr or ip>", one per line.
+// Empty lines and lines starting with # are ignored
+func Parse(r io.Reader) (*ACL, error) {
+	a := &ACL{}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %v: expected \"allow|deny <cidr>\", got %q", line, text)
+		}
+		prefix, err := ParsePrefix(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			a.allow = append(a.allow, prefix)
+		case "deny":
+			a.deny = append(a.deny, prefix)
+		default:
+			return nil, fmt.Errorf("line %v: unknown rule %q", line, fields[0])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func Load(path string) (*ACL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return a, nil
+}
+
+// ParsePrefix parses a CIDR or a single IP
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// Check returns the verdict for the address; deny rules win over allow rules
+func (a *ACL) Check(addr netip.Addr) Verdict {
+	addr = addr.Unmap()
+	if contains(a.deny, addr) {
+		return Deny
+	}
+	if contains(a.allow, addr) {
+		return Allow
+	}
+	return None
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
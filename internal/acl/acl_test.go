@@ -0,0 +1,65 @@
+package acl
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{name: "empty", rules: ""},
+		{name: "comments", rules: "# internal\n\nallow 10.0.0.0/8\n  deny 2001:db8::/32 \n"},
+		{name: "single address", rules: "deny 192.0.2.1"},
+		{name: "unknown rule", rules: "permit 10.0.0.0/8", err: `line 1: unknown rule "permit"`},
+		{name: "missing range", rules: "\nallow", err: `line 2: expected "allow|deny <cidr>", got "allow"`},
+		{name: "invalid range", rules: "allow 10.0.0.0/33", err: `line 1: netip.ParsePrefix("10.0.0.0/33")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.rules))
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestACL_Check(t *testing.T) {
+	a, err := Parse(strings.NewReader(`
+allow 10.0.0.0/8
+deny 10.66.0.0/16
+allow 2001:db8:1::/48
+deny 2001:db8::1
+deny 192.0.2.7
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		addr string
+		want Verdict
+	}{
+		{addr: "10.1.2.3", want: Allow},
+		{addr: "10.66.1.1", want: Deny},
+		{addr: "::ffff:10.1.2.3", want: Allow},
+		{addr: "192.0.2.7", want: Deny},
+		{addr: "192.0.2.8", want: None},
+		{addr: "2001:db8:1::5", want: Allow},
+		{addr: "2001:db8::1", want: Deny},
+		{addr: "2001:db8:2::1", want: None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.want, a.Check(netip.MustParseAddr(tt.addr)))
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package ban
+
+import (
+	"encoding/json"
+	"io"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policy defines when a client gets banned and for how long
+type Policy struct {
+	// Strikes is the number of offences within Window that leads to a ban
+	Strikes int
+	Window  time.Duration
+	// Duration is the first ban length; every next ban of the same client is twice as long, up to MaxDuration
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+var DefaultPolicy = Policy{
+	Strikes:     5,
+	Window:      time.Minute,
+	Duration:    time.Minute,
+	MaxDuration: time.Hour * 24,
+}
+
+// Entry is the state of a single client, exported for inspection and persistence
+type Entry struct {
+	Addr        netip.Addr `json:"addr"`
+	Strikes     int        `json:"strikes"`
+	FirstStrike time.Time  `json:"first_strike"`
+	Bans        int        `json:"bans"`
+	Until       time.Time  `json:"until"`
+}
+
+func (e *Entry) banned(now time.Time) bool {
+	return now.Before(e.Until)
+}
+
+type Manager struct {
+	policy Policy
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[netip.Addr]*Entry
+	dirty   bool
+}
+
+func NewManager(policy Policy) *Manager {
+	if policy.Strikes < 1 {
+		policy.Strikes = 1
+	}
+	if policy.MaxDuration < policy.Duration {
+		policy.MaxDuration = policy.Duration
+	}
+	return &Manager{
+		policy:  policy,
+		now:     time.Now,
+		entries: make(map[netip.Addr]*Entry),
+	}
+}
+
+// Banned reports whether the client is banned and until when
+func (m *Manager) Banned(addr netip.Addr) (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[addr.Unmap()]
+	if !ok || !e.banned(m.now()) {
+		return time.Time{}, false
+	}
+	return e.Until, true
+}
+
+// Strike records an offence and bans the client once it has too many of them.
+// It returns the ban end if the client got banned
+func (m *Manager) Strike(addr netip.Addr) (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	addr = addr.Unmap()
+	now := m.now()
+	e, ok := m.entries[addr]
+	if !ok {
+		e = &Entry{Addr: addr}
+		m.entries[addr] = e
+	}
+	if e.banned(now) {
+		return e.Until, true
+	}
+	m.dirty = true
+
+	if e.Strikes == 0 || now.Sub(e.FirstStrike) > m.policy.Window {
+		e.Strikes = 0
+		e.FirstStrike = now
+	}
+	e.Strikes++
+	if e.Strikes < m.policy.Strikes {
+		return time.Time{}, false
+	}
+
+	e.Strikes = 0
+	e.Until = now.Add(m.banDuration(e.Bans))
+	e.Bans++
+	return e.Until, true
+}
+
+func (m *Manager) banDuration(previousBans int) time.Duration {
+	d := m.policy.Duration
+	for i := 0; i < previousBans && d < m.policy.MaxDuration; i++ {
+		d *= 2
+	}
+	if d > m.policy.MaxDuration {
+		d = m.policy.MaxDuration
+	}
+	return d
+}
+
+// Prune forgets clients that are not banned and have been quiet for MaxDuration, so their next ban starts over
+func (m *Manager) Prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for addr, e := range m.entries {
+		lastSeen := e.FirstStrike
+		if e.Until.After(lastSeen) {
+			lastSeen = e.Until
+		}
+		if now.Sub(lastSeen) > m.policy.MaxDuration {
+			delete(m.entries, addr)
+			m.dirty = true
+		}
+	}
+}
+
+// List returns currently banned clients ordered by address
+func (m *Manager) List() []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var list []Entry
+	for _, e := range m.entries {
+		if e.banned(now) {
+			list = append(list, *e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Addr.Less(list[j].Addr)
+	})
+	return list
+}
+
+// Save writes the state of all known clients as JSON
+func (m *Manager) Save(w io.Writer) error {
+	m.mu.Lock()
+	entries := make([]Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, *e)
+	}
+	m.dirty = false
+	m.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Addr.Less(entries[j].Addr)
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// Load replaces the state with the one previously written by Save
+func (m *Manager) Load(r io.Reader) error {
+	var entries []Entry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = make(map[netip.Addr]*Entry, len(entries))
+	for i := range entries {
+		m.entries[entries[i].Addr] = &entries[i]
+	}
+	return nil
+}
+
+// Dirty reports whether the state changed since the last Save
+func (m *Manager) Dirty() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dirty
+}
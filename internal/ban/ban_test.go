@@ -0,0 +1,126 @@
+package ban
+
+import (
+	"bytes"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestManager() (*Manager, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0).UTC()}
+	m := NewManager(Policy{
+		Strikes:     3,
+		Window:      time.Minute,
+		Duration:    time.Minute,
+		MaxDuration: time.Minute * 3,
+	})
+	m.now = clock.now
+	return m, clock
+}
+
+func TestManager_Strike(t *testing.T) {
+	m, clock := newTestManager()
+	addr := netip.MustParseAddr("10.0.0.1")
+
+	for i := 0; i < 2; i++ {
+		_, banned := m.Strike(addr)
+		assert.False(t, banned)
+	}
+	until, banned := m.Strike(addr)
+	assert.True(t, banned)
+	assert.Equal(t, clock.t.Add(time.Minute), until)
+
+	_, banned = m.Banned(netip.MustParseAddr("::ffff:10.0.0.1"))
+	assert.True(t, banned, "IPv4-mapped address is the same client")
+	_, banned = m.Banned(netip.MustParseAddr("10.0.0.2"))
+	assert.False(t, banned)
+
+	clock.t = until
+	_, banned = m.Banned(addr)
+	assert.False(t, banned, "ban expired")
+
+	for i := 0; i < 3; i++ {
+		until, banned = m.Strike(addr)
+	}
+	assert.True(t, banned)
+	assert.Equal(t, clock.t.Add(time.Minute*2), until, "second ban is longer")
+
+	clock.t = until
+	for i := 0; i < 3; i++ {
+		until, _ = m.Strike(addr)
+	}
+	assert.Equal(t, clock.t.Add(time.Minute*3), until, "ban is capped")
+}
+
+func TestManager_StrikeWindow(t *testing.T) {
+	m, clock := newTestManager()
+	addr := netip.MustParseAddr("2001:db8::1")
+
+	m.Strike(addr)
+	m.Strike(addr)
+	clock.t = clock.t.Add(time.Minute * 2)
+	_, banned := m.Strike(addr)
+	assert.False(t, banned, "old strikes are forgotten")
+}
+
+func TestManager_Prune(t *testing.T) {
+	m, clock := newTestManager()
+	for i := 0; i < 3; i++ {
+		m.Strike(netip.MustParseAddr("10.0.0.1"))
+	}
+	m.Strike(netip.MustParseAddr("10.0.0.2"))
+
+	m.Prune()
+	assert.Len(t, m.entries, 2)
+
+	clock.t = clock.t.Add(time.Minute * 5)
+	m.Prune()
+	assert.Empty(t, m.entries)
+}
+
+func TestManager_SaveLoad(t *testing.T) {
+	m, clock := newTestManager()
+	for i := 0; i < 3; i++ {
+		m.Strike(netip.MustParseAddr("10.0.0.1"))
+	}
+	assert.True(t, m.Dirty())
+
+	var buf bytes.Buffer
+	require.NoError(t, m.Save(&buf))
+	assert.False(t, m.Dirty())
+
+	restored, _ := newTestManager()
+	restored.now = clock.now
+	require.NoError(t, restored.Load(&buf))
+	assert.Equal(t, m.List(), restored.List())
+	assert.Len(t, restored.List(), 1)
+}
+
+func TestManager_SaveLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+
+	m, _ := newTestManager()
+	require.NoError(t, m.LoadFile(path), "missing file is fine")
+
+	for i := 0; i < 3; i++ {
+		m.Strike(netip.MustParseAddr("10.0.0.1"))
+	}
+	require.NoError(t, m.SaveFile(path))
+
+	restored, _ := newTestManager()
+	require.NoError(t, restored.LoadFile(path))
+	assert.Equal(t, m.entries, restored.entries)
+}
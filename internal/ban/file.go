@@ -0,0 +1,68 @@
+package ban
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LoadFile restores the state from path; a missing file means a fresh start
+func (m *Manager) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.Load(f)
+}
+
+// SaveFile atomically replaces path with the current state
+func (m *Manager) SaveFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := m.Save(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Run prunes the state and saves it to path (if not empty) every period and once more when ctx is done
+func (m *Manager) Run(ctx context.Context, path string, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	save := func() {
+		if path == "" || !m.Dirty() {
+			return
+		}
+		if err := m.SaveFile(path); err != nil {
+			log.Printf("error saving bans: %v", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			save()
+			return
+		case <-ticker.C:
+			m.Prune()
+			save()
+		}
+	}
+}
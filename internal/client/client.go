@@ -0,0 +1,423 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+)
+
+var ErrInvalidSolution = errors.New("server rejected the solution")
+
+var ErrOverloaded = errors.New("server is overloaded")
+
+var ErrRateLimited = errors.New("rate limited by server")
+
+var ErrResponseTooLarge = errors.New("response does not fit in a datagram")
+
+// Quote is a successfully fetched quote along with the puzzle that was solved to get it
+type Quote struct {
+	Text      string
+	Challenge protocol.Challenge
+	Hash      string
+}
+
+// RetryPolicy controls how FetchQuote repeats the whole hello-solve-request flow after a failure
+type RetryPolicy struct {
+	// Attempts is the total number of tries; values below 1 mean a single try
+	Attempts int
+	Backoff  time.Duration
+}
+
+type Client struct {
+	// Addr is the host:port of the quote server
+	Addr string
+	// Network is "tcp" (the default), "unix" with a socket path as Addr, or "udp".
+	// Over UDP every request is a single datagram and lost ones are only repeated by Retry
+	Network string
+	// IOTimeout limits every single exchange with the server, dial included
+	IOTimeout time.Duration
+	// Limits refuses challenges that require more work than the client is ready to do
+	Limits puzzle.Limits
+	// TLS, if set, makes the client talk to the server over TLS, see tlsutil.ClientConfig
+	TLS *tls.Config
+	// ServerKey, if set, makes the client accept only challenges signed by the server, see puzzle.VerifyChallenge
+	ServerKey ed25519.PublicKey
+	Retry     RetryPolicy
+	// ProgressInterval and OnProgress are passed to the solver, see puzzle.SolveContext
+	ProgressInterval time.Duration
+	OnProgress       func(protocol.Challenge, puzzle.Progress)
+	// Logf receives debug messages if set
+	Logf func(format string, args ...any)
+}
+
+func New(addr string) *Client {
+	return &Client{
+		Addr:             addr,
+		IOTimeout:        time.Second * 10,
+		Retry:            RetryPolicy{Attempts: 1},
+		ProgressInterval: time.Second * 2,
+	}
+}
+
+// FetchQuote solves a puzzle given by the server and exchanges the solution for a quote
+func (c *Client) FetchQuote(ctx context.Context) (Quote, error) {
+	attempts := c.Retry.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var q Quote
+		if q, err = c.fetchOnce(ctx); err == nil {
+			return q, nil
+		}
+		if attempt >= attempts || !retryable(err) {
+			return Quote{}, err
+		}
+		c.logf("attempt %v failed, retrying in %v: %v", attempt, c.Retry.Backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return Quote{}, ctx.Err()
+		case <-time.After(c.Retry.Backoff):
+		}
+	}
+}
+
+func (c *Client) fetchOnce(ctx context.Context) (q Quote, err error) {
+	hello, err := c.say(ctx, protocol.Hello)
+	if err != nil {
+		return q, fmt.Errorf("error saying to server: %w", err)
+	}
+	if err := responseError(hello.body); err != nil {
+		return q, err
+	}
+
+	q.Challenge, err = protocol.ChallengeFromBytes(hello.body)
+	if err != nil {
+		return q, fmt.Errorf("error parsing challenge: %w", err)
+	}
+
+	if c.ServerKey != nil {
+		if err := puzzle.VerifyChallenge(c.ServerKey, q.Challenge); err != nil {
+			return q, err
+		}
+	}
+
+	if err := c.Limits.Check(q.Challenge); err != nil {
+		return q, fmt.Errorf("refusing challenge from server: %w", err)
+	}
+
+	clientID, serverID, err := ids(q.Challenge, hello)
+	if err != nil {
+		return q, fmt.Errorf("unable to detect client id: %w", err)
+	}
+
+	c.logf("solving challenge from server: %v, expecting %.0f attempts on average", q.Challenge, puzzle.ExpectedAttempts(q.Challenge.Complexity))
+
+	hashData := protocol.HashData{
+		ClientID:    clientID,
+		NonceServer: q.Challenge.Nonce,
+		NonceClient: puzzle.GenerateNonceOnce(),
+	}
+
+	var onProgress func(puzzle.Progress)
+	if c.OnProgress != nil {
+		onProgress = func(p puzzle.Progress) {
+			c.OnProgress(q.Challenge, p)
+		}
+	}
+	q.Hash, err = puzzle.SolveContext(ctx, &hashData, q.Challenge, c.ProgressInterval, onProgress)
+	if err != nil {
+		return q, fmt.Errorf("error solving challenge: %w", err)
+	}
+	c.logf("found solution: %v", q.Hash)
+
+	quoteReq := protocol.QuoteRequest{
+		ServerID: serverID,
+		HashData: hashData,
+	}
+	c.logf("making quote request: %q", quoteReq.Bytes())
+
+	quote, err := c.say(ctx, quoteReq.Bytes())
+	if err != nil {
+		return q, fmt.Errorf("error sending solution: %w", err)
+	}
+	if bytes.Equal(quote.body, protocol.InvalidSolution) {
+		return q, ErrInvalidSolution
+	}
+	if err := responseError(quote.body); err != nil {
+		return q, err
+	}
+
+	q.Text = string(quote.body)
+	return q, nil
+}
+
+// Dial solves a puzzle on a single connection and returns that connection once the server accepts the solution,
+// for servers proxying to another service, see server.WithSingleConnection and server.Proxy.
+// Retry is not applied. The returned connection has no deadline
+func (c *Client) Dial(ctx context.Context) (net.Conn, error) {
+	if c.network() == "udp" {
+		return nil, errors.New("single connection flow is not supported over UDP")
+	}
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := c.session(ctx, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+func (c *Client) session(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReader(conn)
+	if _, err := conn.Write(append(append([]byte{}, protocol.Hello...), '\n')); err != nil {
+		return nil, fmt.Errorf("error saying to server: %w", err)
+	}
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading challenge: %w", err)
+	}
+	if bytes.Equal(line, protocol.Accepted) {
+		// the client is exempt from the puzzle
+		return accepted(conn, r)
+	}
+	if err := responseError(line); err != nil {
+		return nil, err
+	}
+
+	challenge, err := protocol.ChallengeFromBytes(line)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing challenge: %w", err)
+	}
+	if c.ServerKey != nil {
+		if err := puzzle.VerifyChallenge(c.ServerKey, challenge); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Limits.Check(challenge); err != nil {
+		return nil, fmt.Errorf("refusing challenge from server: %w", err)
+	}
+	clientID, serverID, err := ids(challenge, reply{local: conn.LocalAddr(), remote: conn.RemoteAddr()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to detect client id: %w", err)
+	}
+
+	c.logf("solving challenge from server: %v, expecting %.0f attempts on average", challenge, puzzle.ExpectedAttempts(challenge.Complexity))
+	quoteReq := protocol.QuoteRequest{
+		ServerID: serverID,
+		HashData: protocol.HashData{
+			ClientID:    clientID,
+			NonceServer: challenge.Nonce,
+			NonceClient: puzzle.GenerateNonceOnce(),
+		},
+	}
+	var onProgress func(puzzle.Progress)
+	if c.OnProgress != nil {
+		onProgress = func(p puzzle.Progress) {
+			c.OnProgress(challenge, p)
+		}
+	}
+	if _, err := puzzle.SolveContext(ctx, &quoteReq.HashData, challenge, c.ProgressInterval, onProgress); err != nil {
+		return nil, fmt.Errorf("error solving challenge: %w", err)
+	}
+
+	if _, err := conn.Write(append(quoteReq.Bytes(), '\n')); err != nil {
+		return nil, fmt.Errorf("error sending solution: %w", err)
+	}
+	line, err = readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading solution response: %w", err)
+	}
+	if bytes.Equal(line, protocol.InvalidSolution) {
+		return nil, ErrInvalidSolution
+	}
+	if !bytes.Equal(line, protocol.Accepted) {
+		return nil, fmt.Errorf("unexpected response to solution: %q", line)
+	}
+	return accepted(conn, r)
+}
+
+func accepted(conn net.Conn, r *bufio.Reader) (net.Conn, error) {
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &sessionConn{Conn: conn, r: r}, nil
+}
+
+// readLine reads a line without the line break; responses the server closes the connection after have none
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		return line, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(line, []byte("\n")), nil
+}
+
+// sessionConn reads what is left in the buffer first
+type sessionConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *sessionConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite tells the other side nothing more will be sent, if the connection supports it
+func (c *sessionConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("connection does not support closing for writing")
+}
+
+// ids returns the client and server IDs for the quote request.
+// Values sent by the server are preferred, for older servers the addresses of the hello connection are used
+func ids(challenge protocol.Challenge, hello reply) (string, string, error) {
+	serverID := challenge.ServerID
+	if serverID == "" {
+		serverID = hello.remote.String()
+	}
+	if challenge.ClientAddr != "" {
+		return challenge.ClientAddr, serverID, nil
+	}
+
+	locAddr, err := netip.ParseAddrPort(hello.local.String())
+	if err != nil {
+		return "", "", err
+	}
+	return locAddr.Addr().String(), serverID, nil
+}
+
+func (c *Client) logf(format string, args ...any) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+	}
+}
+
+func (c *Client) network() string {
+	if c.Network == "" {
+		return "tcp"
+	}
+	return c.Network
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	if c.network() == "udp" && c.TLS != nil {
+		return nil, errors.New("TLS is not supported over UDP")
+	}
+	d := net.Dialer{Timeout: c.IOTimeout}
+	conn, err := d.DialContext(ctx, c.network(), c.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(c.IOTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if c.TLS == nil {
+		return conn, nil
+	}
+	cfg := c.TLS
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		if cfg.ServerName, _, err = net.SplitHostPort(c.Addr); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// reply is a server response along with the addresses of the connection it came from
+type reply struct {
+	body   []byte
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *Client) say(ctx context.Context, what []byte) (reply, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return reply{}, err
+	}
+	defer conn.Close()
+
+	msg := make([]byte, 0, len(what)+1)
+	msg = append(msg, what...)
+	msg = append(msg, '\n')
+	if c.network() == "udp" && len(msg) < protocol.HelloDatagramSize {
+		// the server does not answer with more bytes than it gets
+		msg = append(msg, make([]byte, protocol.HelloDatagramSize-len(msg))...)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return reply{}, err
+	}
+
+	var body []byte
+	if c.network() == "udp" {
+		buf := make([]byte, protocol.MaxDatagramSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return reply{}, err
+		}
+		body = buf[:n]
+	} else if body, err = io.ReadAll(conn); err != nil {
+		return reply{}, err
+	}
+	return reply{body: body, local: conn.LocalAddr(), remote: conn.RemoteAddr()}, nil
+}
+
+// responseError recognizes responses the server sends instead of serving a request
+func responseError(response []byte) error {
+	switch {
+	case bytes.Equal(response, protocol.Overloaded):
+		return ErrOverloaded
+	case bytes.Equal(response, protocol.RateLimited):
+		return ErrRateLimited
+	case bytes.Equal(response, protocol.ResponseTooLarge):
+		return ErrResponseTooLarge
+	}
+	return nil
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, puzzle.ErrChallengeTooHard) || errors.Is(err, puzzle.ErrBadSignature) || errors.Is(err, ErrResponseTooLarge) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve is a minimal quote server answering every solution with the given response
+func serve(t *testing.T, challenge protocol.Challenge, responses ...string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			req, _ := puzzle.ReadRequest(conn)
+			switch req.(type) {
+			case protocol.ChallengeRequest:
+				_, _ = conn.Write(challenge.Bytes())
+			case protocol.QuoteRequest:
+				_, _ = conn.Write([]byte(responses[0]))
+				if len(responses) > 1 {
+					responses = responses[1:]
+				}
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestClient_FetchQuote(t *testing.T) {
+	challenge := protocol.Challenge{Nonce: 111, Complexity: 1}
+	c := New(serve(t, challenge, "quote"))
+
+	q, err := c.FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "quote", q.Text)
+	assert.Equal(t, challenge, q.Challenge)
+	assert.True(t, puzzle.HashMatchesChallenge(q.Hash, challenge))
+}
+
+func TestClient_FetchQuote_Retry(t *testing.T) {
+	challenge := protocol.Challenge{Nonce: 111, Complexity: 1}
+	c := New(serve(t, challenge, string(protocol.InvalidSolution), "quote"))
+
+	_, err := c.FetchQuote(context.Background())
+	assert.ErrorIs(t, err, ErrInvalidSolution)
+
+	c.Retry = RetryPolicy{Attempts: 2, Backoff: time.Millisecond}
+	q, err := c.FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "quote", q.Text)
+}
+
+func TestClient_FetchQuote_TooHard(t *testing.T) {
+	challenge := protocol.Challenge{Nonce: 111, Complexity: 40}
+	c := New(serve(t, challenge, "quote"))
+	c.Limits = puzzle.Limits{MaxComplexity: 6}
+	c.Retry = RetryPolicy{Attempts: 3}
+
+	_, err := c.FetchQuote(context.Background())
+	assert.ErrorIs(t, err, puzzle.ErrChallengeTooHard)
+}
+
+func TestIDs(t *testing.T) {
+	hello := reply{
+		local:  &net.TCPAddr{IP: net.IP{192, 168, 0, 5}, Port: 5555},
+		remote: &net.TCPAddr{IP: net.IP{172, 18, 0, 2}, Port: 9999},
+	}
+
+	clientID, serverID, err := ids(protocol.Challenge{ClientAddr: "203.0.113.7"}, hello)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", clientID, "address observed by the server is preferred")
+	assert.Equal(t, "172.18.0.2:9999", serverID)
+
+	_, serverID, err = ids(protocol.Challenge{ClientAddr: "203.0.113.7", ServerID: "quotes.example.com"}, hello)
+	require.NoError(t, err)
+	assert.Equal(t, "quotes.example.com", serverID, "server id advertised by the server is preferred")
+
+	clientID, _, err = ids(protocol.Challenge{}, hello)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.0.5", clientID, "local address is used with older servers")
+}
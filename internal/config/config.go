@@ -0,0 +1,338 @@
+package config
+
+import (
+	"crypto/sha1"
+	"encoding"
+	"fmt"
+	"io"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"powquote/internal/acl"
+	"powquote/internal/protocol"
+	"powquote/internal/server"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxComplexity is the number of hex digits in a solution hash
+const maxComplexity = sha1.Size * 2
+
+// Config is the server configuration.
+// Every setting has a key in the configuration file, an environment variable with the upper case key, e.g. RATE_LIMIT,
+// and a command line flag with dashes, e.g. -rate-limit. Settings tagged live can be changed without a restart, see Diff
+type Config struct {
+	Listen        string   `yaml:"listen" json:"listen" usage:"address for the TCP protocol, host:port or unix:/path"`
+	HTTPListen    string   `yaml:"http_listen" json:"http_listen" usage:"address for the HTTP gateway, host:port or unix:/path"`
+	UDPListen     string   `yaml:"udp_listen" json:"udp_listen" usage:"address for the UDP transport, host:port"`
+	Listeners     []string `yaml:"listeners" json:"listeners" usage:"listener specs, e.g. tcp://:8080,https://:8443"`
+	MetricsListen string   `yaml:"metrics_listen" json:"metrics_listen" usage:"address for Prometheus metrics on /metrics, host:port or unix:/path"`
+
+	Protected      bool `yaml:"protected" json:"protected" reload:"live" usage:"require a solved puzzle before serving a quote"`
+	Complexity     int  `yaml:"complexity" json:"complexity" reload:"live" usage:"puzzle complexity, leading zero hex digits of the solution hash"`
+	MaxConnections int  `yaml:"max_connections" json:"max_connections" usage:"maximum number of connections handled at once"`
+
+	RateLimit       float64 `yaml:"rate_limit" json:"rate_limit" reload:"live" usage:"connections per second allowed from one IP, 0 for unlimited"`
+	RateBurst       int     `yaml:"rate_burst" json:"rate_burst" reload:"live" usage:"burst of connections allowed from one IP"`
+	SubnetRateLimit float64 `yaml:"subnet_rate_limit" json:"subnet_rate_limit" reload:"live" usage:"connections per second allowed from one subnet, 0 for unlimited"`
+	SubnetRateBurst int     `yaml:"subnet_rate_burst" json:"subnet_rate_burst" reload:"live" usage:"burst of connections allowed from one subnet"`
+	IPv4Prefix      int     `yaml:"ipv4_prefix" json:"ipv4_prefix" reload:"live" usage:"prefix length of an IPv4 client subnet"`
+	IPv6Prefix      int     `yaml:"ipv6_prefix" json:"ipv6_prefix" reload:"live" usage:"prefix length of an IPv6 client subnet"`
+
+	RequestTimeout Duration `yaml:"request_timeout" json:"request_timeout" usage:"time a client has to send its request"`
+	DrainTimeout   Duration `yaml:"drain_timeout" json:"drain_timeout" usage:"time in-flight connections have to finish on shutdown"`
+	SilentRejects  bool     `yaml:"silent_rejects" json:"silent_rejects" usage:"close rejected connections without a response"`
+	LogLevel       string   `yaml:"log_level" json:"log_level" reload:"live" usage:"messages about clients to log: info, warn or error"`
+
+	ACLFile     string   `yaml:"acl_file" json:"acl_file" reload:"live" usage:"file with allow and deny rules"`
+	BanStrikes  int      `yaml:"ban_strikes" json:"ban_strikes" usage:"invalid solutions within a minute before a ban, 0 disables bans"`
+	BanDuration Duration `yaml:"ban_duration" json:"ban_duration" usage:"duration of the first ban"`
+	BansFile    string   `yaml:"bans_file" json:"bans_file" usage:"file the bans are saved to and restored from"`
+
+	ProxyProtocol   string   `yaml:"proxy_protocol" json:"proxy_protocol" usage:"PROXY protocol: on, off or optional"`
+	ProxyTrusted    []string `yaml:"proxy_trusted" json:"proxy_trusted" usage:"CIDRs or IPs of the proxies allowed to send PROXY headers"`
+	ServerID        []string `yaml:"server_id" json:"server_id" usage:"names the server is known by, the first one is advertised"`
+	IdentityKeyFile string   `yaml:"identity_key_file" json:"identity_key_file" usage:"file with the ed25519 seed challenges are signed with"`
+
+	TLSCert          string `yaml:"tls_cert" json:"tls_cert" usage:"PEM certificate file"`
+	TLSKey           string `yaml:"tls_key" json:"tls_key" usage:"PEM key file"`
+	TLSClientCA      string `yaml:"tls_client_ca" json:"tls_client_ca" usage:"PEM file with the CAs client certificates are verified with"`
+	ClientPolicyFile string `yaml:"client_policy_file" json:"client_policy_file" usage:"JSON file with client certificate policies"`
+
+	QuotesFile          string   `yaml:"quotes_file" json:"quotes_file" reload:"live" usage:"file with the quotes to serve, one per line, instead of the built-in ones"`
+	Upstream            string   `yaml:"upstream" json:"upstream" usage:"TCP service clients are connected to after the puzzle"`
+	UpstreamIdleTimeout Duration `yaml:"upstream_idle_timeout" json:"upstream_idle_timeout" usage:"idle time after which a proxied session is closed"`
+}
+
+// Default returns the configuration used for settings that are not set anywhere
+func Default() Config {
+	return Config{
+		Protected:           true,
+		Complexity:          5,
+		MaxConnections:      1000,
+		RateBurst:           10,
+		SubnetRateBurst:     100,
+		IPv4Prefix:          24,
+		IPv6Prefix:          64,
+		RequestTimeout:      Duration(time.Second * 5),
+		DrainTimeout:        Duration(time.Second * 10),
+		LogLevel:            "info",
+		BanStrikes:          5,
+		BanDuration:         Duration(time.Minute),
+		ProxyProtocol:       "off",
+		UpstreamIdleTimeout: Duration(time.Minute * 5),
+	}
+}
+
+// Validate checks every setting and reports all problems at once
+func (c Config) Validate() error {
+	var errs Errors
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%v: %v", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	for _, spec := range c.Listeners {
+		l, err := server.ParseListener(spec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("listeners: %w", err))
+			continue
+		}
+		check(c.TLSCert != "" || (l.Scheme != "tls" && l.Scheme != "https"), "listeners", "%v needs tls_cert", spec)
+		// proxied sessions need a connection
+		check(c.Upstream == "" || l.Scheme != "udp", "listeners", "%v cannot be used with upstream", spec)
+	}
+	if strings.HasPrefix(c.UDPListen, "unix:") {
+		errs = append(errs, fmt.Errorf("udp_listen: should be host:port"))
+	}
+	check(c.Upstream == "" || c.UDPListen == "", "udp_listen", "cannot be used with upstream")
+
+	if c.Protected {
+		check(c.Complexity >= 1 && c.Complexity <= maxComplexity, "complexity", "should be between 1 and %v, got %v", maxComplexity, c.Complexity)
+	}
+	check(c.MaxConnections > 0, "max_connections", "should be positive, got %v", c.MaxConnections)
+
+	check(c.RateLimit >= 0, "rate_limit", "should not be negative, got %v", c.RateLimit)
+	check(c.RateBurst > 0 || c.RateLimit == 0, "rate_burst", "should be positive, got %v", c.RateBurst)
+	check(c.SubnetRateLimit >= 0, "subnet_rate_limit", "should not be negative, got %v", c.SubnetRateLimit)
+	check(c.SubnetRateBurst > 0 || c.SubnetRateLimit == 0, "subnet_rate_burst", "should be positive, got %v", c.SubnetRateBurst)
+	check(c.IPv4Prefix >= 1 && c.IPv4Prefix <= 32, "ipv4_prefix", "should be between 1 and 32, got %v", c.IPv4Prefix)
+	check(c.IPv6Prefix >= 1 && c.IPv6Prefix <= 128, "ipv6_prefix", "should be between 1 and 128, got %v", c.IPv6Prefix)
+
+	check(c.RequestTimeout > 0, "request_timeout", "should be positive, got %v", c.RequestTimeout)
+	check(c.DrainTimeout >= 0, "drain_timeout", "should not be negative, got %v", c.DrainTimeout)
+	if _, err := server.ParseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+
+	check(c.BanStrikes >= 0, "ban_strikes", "should not be negative, got %v", c.BanStrikes)
+	check(c.BanDuration > 0 || c.BanStrikes == 0, "ban_duration", "should be positive, got %v", c.BanDuration)
+
+	check(c.ProxyProtocol == "" || c.ProxyProtocol == "on" || c.ProxyProtocol == "off" || c.ProxyProtocol == "optional", "proxy_protocol", "should be on, off or optional, got %q", c.ProxyProtocol)
+	check(c.ProxyProtocol != "on" && c.ProxyProtocol != "optional" || len(c.ProxyTrusted) > 0, "proxy_trusted", "needed with proxy_protocol %v, or any client could pose as another", c.ProxyProtocol)
+	if _, err := c.ProxyTrustedPrefixes(); err != nil {
+		errs = append(errs, fmt.Errorf("proxy_trusted: %w", err))
+	}
+	for _, name := range c.ServerID {
+		if err := protocol.ValidServerID(name); err != nil {
+			errs = append(errs, fmt.Errorf("server_id: %w", err))
+		}
+	}
+
+	check((c.TLSCert == "") == (c.TLSKey == ""), "tls_cert", "tls_cert and tls_key should be set together")
+	check(c.TLSClientCA == "" || c.TLSCert != "", "tls_client_ca", "needs tls_cert")
+	check(c.ClientPolicyFile == "" || c.TLSClientCA != "", "client_policy_file", "needs tls_client_ca to verify client certificates")
+
+	check(c.QuotesFile == "" || c.Upstream == "", "quotes_file", "cannot be used with upstream")
+	check(c.UpstreamIdleTimeout > 0 || c.Upstream == "", "upstream_idle_timeout", "should be positive, got %v", c.UpstreamIdleTimeout)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ProxyTrustedPrefixes parses ProxyTrusted
+func (c Config) ProxyTrustedPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range c.ProxyTrusted {
+		prefix, err := acl.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// Print writes the configuration as YAML, which can be used as a configuration file
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Errors are all problems found in a configuration
+type Errors []error
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return "invalid configuration:\n\t" + strings.Join(lines, "\n\t")
+}
+
+// Duration is a time.Duration written as a string like 10s in files
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("should be duration like 10s, got %q", text)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Change is a setting that differs between two configurations
+type Change struct {
+	Key      string
+	Old, New string
+	// Live changes can be applied to the running server, others need a restart
+	Live bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%v changed from %q to %q", c.Key, c.Old, c.New)
+}
+
+// Diff lists the settings that differ between the running configuration and the next one
+func Diff(running, next Config) []Change {
+	var changes []Change
+	for _, f := range fields() {
+		if old, new := running.get(f), next.get(f); old != new {
+			changes = append(changes, Change{Key: f.key, Old: old, New: new, Live: f.live})
+		}
+	}
+	return changes
+}
+
+// WithLive returns c with the live settings taken from next, i.e. the configuration in use after a reload
+func (c Config) WithLive(next Config) Config {
+	v, nv := reflect.ValueOf(&c).Elem(), reflect.ValueOf(next)
+	for _, f := range fields() {
+		if f.live {
+			v.Field(f.index).Set(nv.Field(f.index))
+		}
+	}
+	return c
+}
+
+// field is a setting of Config with its names
+type field struct {
+	key   string
+	usage string
+	live  bool
+	index int
+}
+
+func (f field) env() string {
+	return strings.ToUpper(f.key)
+}
+
+func (f field) flag() string {
+	return strings.ReplaceAll(f.key, "_", "-")
+}
+
+func (f field) isBool() bool {
+	return reflect.TypeOf(Config{}).Field(f.index).Type.Kind() == reflect.Bool
+}
+
+// fields lists the settings of Config in declaration order
+func fields() []field {
+	t := reflect.TypeOf(Config{})
+	list := make([]field, t.NumField())
+	for i := range list {
+		sf := t.Field(i)
+		list[i] = field{key: sf.Tag.Get("yaml"), usage: sf.Tag.Get("usage"), live: sf.Tag.Get("reload") == "live", index: i}
+	}
+	return list
+}
+
+// get formats the value of the field the way set parses it
+func (c Config) get(f field) string {
+	v := reflect.ValueOf(c).Field(f.index)
+	if list, ok := v.Interface().([]string); ok {
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// set parses the value of the field from a string as it comes in a variable or flag; lists are comma separated
+func (c *Config) set(f field, s string) error {
+	v := reflect.ValueOf(c).Elem().Field(f.index)
+	if v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := parseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("should be integer, got %q", s)
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("should be number, got %q", s)
+		}
+		v.SetFloat(x)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		panic("unsupported config field type " + v.Type().String())
+	}
+	return nil
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "t", "true", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("should be bool, got %q", s)
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func load(t *testing.T, args []string, vars map[string]string) (Config, error) {
+	l, err := NewLoader("server", args, env(vars), io.Discard)
+	require.NoError(t, err)
+	return l.Load()
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load(t, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "server.yaml", `
+listen: ":8080"
+complexity: 3
+rate_limit: 2.5
+request_timeout: 2s
+server_id: [a.example, b.example]
+`)
+	cfg, err := load(t, []string{"-complexity", "7", "-silent-rejects"}, map[string]string{
+		FileVar:      path,
+		"COMPLEXITY": "6",
+		"RATE_BURST": "20",
+		"PROTECTED":  "yes",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ":8080", cfg.Listen)
+	assert.Equal(t, 7, cfg.Complexity)
+	assert.Equal(t, 2.5, cfg.RateLimit)
+	assert.Equal(t, 20, cfg.RateBurst)
+	assert.Equal(t, Duration(time.Second*2), cfg.RequestTimeout)
+	assert.Equal(t, []string{"a.example", "b.example"}, cfg.ServerID)
+	assert.True(t, cfg.SilentRejects)
+	assert.True(t, cfg.Protected)
+}
+
+func TestLoad_JSON(t *testing.T) {
+	path := writeFile(t, "server.json", `{"http_listen": ":8081", "ban_duration": "5m", "listeners": ["udp://:8082"]}`)
+	cfg, err := load(t, []string{"-config", path, "-server-id", "a.example, b.example"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, ":8081", cfg.HTTPListen)
+	assert.Equal(t, Duration(time.Minute*5), cfg.BanDuration)
+	assert.Equal(t, []string{"udp://:8082"}, cfg.Listeners)
+	assert.Equal(t, []string{"a.example", "b.example"}, cfg.ServerID)
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path := writeFile(t, "server.toml", `
+# comments are ignored
+http_listen = ":8081" # after values too
+complexity = 6
+rate_limit = 2
+silent_rejects = true
+ban_duration = "5m"
+acl_file = 'C:\pow\acl.txt'
+listeners = [
+  "udp://:8082",
+  "tcp://[::1]:8083", # trailing comma
+]
+server_id = ["a.example", "b#example"]
+bans_file = "bans[.txt"
+log_level = "warn"
+`)
+	cfg, err := load(t, []string{"-config", path}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, ":8081", cfg.HTTPListen)
+	assert.Equal(t, 6, cfg.Complexity)
+	assert.Equal(t, 2.0, cfg.RateLimit)
+	assert.True(t, cfg.SilentRejects)
+	assert.Equal(t, Duration(time.Minute*5), cfg.BanDuration)
+	assert.Equal(t, `C:\pow\acl.txt`, cfg.ACLFile)
+	assert.Equal(t, []string{"udp://:8082", "tcp://[::1]:8083"}, cfg.Listeners)
+	assert.Equal(t, []string{"a.example", "b#example"}, cfg.ServerID)
+	assert.Equal(t, "bans[.txt", cfg.BansFile, "brackets in strings do not start an array")
+	assert.Equal(t, "warn", cfg.LogLevel)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	_, err := load(t, nil, map[string]string{FileVar: writeFile(t, "server.yaml", "complexty: 3\n")})
+	assert.ErrorContains(t, err, "field complexty not found")
+
+	_, err = load(t, nil, map[string]string{FileVar: writeFile(t, "server.ini", "complexity = 3\n")})
+	assert.ErrorContains(t, err, "unknown format")
+
+	_, err = load(t, nil, map[string]string{FileVar: writeFile(t, "server.toml", "complexty = 3\n")})
+	assert.ErrorContains(t, err, `line 1: unknown key "complexty"`)
+
+	_, err = load(t, nil, map[string]string{FileVar: writeFile(t, "server.toml", "\ncomplexity = \"3\"\n")})
+	assert.ErrorContains(t, err, "line 2: complexity: should be integer")
+
+	_, err = load(t, nil, map[string]string{FileVar: writeFile(t, "server.toml", "[server]\ncomplexity = 3\n")})
+	assert.ErrorContains(t, err, "tables are not supported")
+
+	_, err = load(t, []string{"-rate-limit", "fast"}, map[string]string{"COMPLEXITY": "high"})
+	assert.EqualError(t, err, "invalid configuration:\n\tCOMPLEXITY variable: should be integer, got \"high\"\n\t-rate-limit flag: should be number, got \"fast\"")
+
+	_, err = NewLoader("server", []string{"-complexity"}, env(nil), io.Discard)
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Complexity = 50
+	cfg.MaxConnections = 0
+	cfg.RateLimit = 1
+	cfg.RateBurst = 0
+	cfg.ProxyProtocol = "maybe"
+	cfg.ProxyTrusted = []string{"10.0.0.0/33"}
+	cfg.ServerID = []string{"a--b"}
+	cfg.Listeners = []string{"tls://:8443", "ftp://:21"}
+	cfg.ClientPolicyFile = "policies.json"
+	cfg.IPv4Prefix = 0
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	errs := err.(Errors)
+	assert.Len(t, errs, 10)
+	assert.Contains(t, err.Error(), "complexity: should be between 1 and 40, got 50")
+	assert.Contains(t, err.Error(), "listeners: tls://:8443 needs tls_cert")
+	assert.Contains(t, err.Error(), "client_policy_file: needs tls_client_ca")
+	assert.Contains(t, err.Error(), "ipv4_prefix: should be between 1 and 32, got 0")
+
+	cfg = Default()
+	cfg.Upstream = "127.0.0.1:22"
+	cfg.UDPListen = ":8080"
+	cfg.Listeners = []string{"udp://:8081"}
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "udp_listen: cannot be used with upstream")
+	assert.ErrorContains(t, err, "listeners: udp://:8081 cannot be used with upstream")
+
+	cfg = Default()
+	cfg.ProxyProtocol = "optional"
+	assert.ErrorContains(t, cfg.Validate(), "proxy_trusted: needed with proxy_protocol optional")
+	cfg.ProxyTrusted = []string{"10.0.0.0/8", "192.0.2.1"}
+	assert.NoError(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Protected = false
+	cfg.Complexity = 0
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestConfig_Print(t *testing.T) {
+	cfg := Default()
+	cfg.ServerID = []string{"a.example"}
+	cfg.Listeners = []string{"tcp://:8080"}
+	cfg.ProxyTrusted = []string{"10.0.0.0/8"}
+	var buf bytes.Buffer
+	require.NoError(t, cfg.Print(&buf))
+	assert.Contains(t, buf.String(), "request_timeout: 5s\n")
+
+	cfg2, err := load(t, nil, map[string]string{FileVar: writeFile(t, "printed.yaml", buf.String())})
+	require.NoError(t, err)
+	assert.Equal(t, cfg, cfg2)
+}
+
+func TestDiff(t *testing.T) {
+	running := Default()
+	next := Default()
+	next.Complexity = 6
+	next.Listen = ":9090"
+	next.ServerID = []string{"a.example"}
+
+	assert.Equal(t, []Change{
+		{Key: "listen", Old: "", New: ":9090"},
+		{Key: "complexity", Old: "5", New: "6", Live: true},
+		{Key: "server_id", Old: "", New: "a.example"},
+	}, Diff(running, next))
+
+	applied := running.WithLive(next)
+	assert.Equal(t, 6, applied.Complexity)
+	assert.Equal(t, "", applied.Listen)
+	assert.Nil(t, applied.ServerID)
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileVar is the environment variable with the configuration file path, overridden by the -config flag
+const FileVar = "CONFIG"
+
+// Loader builds the configuration from the defaults, a file, environment variables and command line flags, each overriding the previous ones
+type Loader struct {
+	// File is the configuration file, YAML, JSON or TOML by extension; none when empty
+	File string
+	// PrintConfig is set by the -print-config flag
+	PrintConfig bool
+
+	lookupEnv func(string) (string, bool)
+	flags     map[string]string
+}
+
+// NewLoader parses the command line flags in args, without the program name; lookupEnv is usually os.LookupEnv.
+// Usage is written to output on -help and on flag errors
+func NewLoader(name string, args []string, lookupEnv func(string) (string, bool), output io.Writer) (*Loader, error) {
+	l := &Loader{lookupEnv: lookupEnv, flags: make(map[string]string)}
+	if path, ok := lookupEnv(FileVar); ok && path != "" {
+		l.File = path
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&l.File, "config", l.File, "configuration file, YAML, JSON or TOML ("+FileVar+")")
+	fs.BoolVar(&l.PrintConfig, "print-config", false, "print the resulting configuration as YAML and exit")
+	defaults := Default()
+	for _, f := range fields() {
+		usage := fmt.Sprintf("%v (%v)", f.usage, f.env())
+		if def := defaults.get(f); def != "" {
+			usage = fmt.Sprintf("%v (%v, default %v)", f.usage, f.env(), def)
+		}
+		fs.Var(&flagValue{flags: l.flags, key: f.key, isBool: f.isBool()}, f.flag(), usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	return l, nil
+}
+
+// Load reads the file and the environment again and applies the flags on top, then validates the result
+func (l *Loader) Load() (Config, error) {
+	cfg := Default()
+	if l.File != "" {
+		if err := readFile(l.File, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var errs Errors
+	for _, f := range fields() {
+		// empty variables are treated as unset, as they always were
+		if v, ok := l.lookupEnv(f.env()); ok && v != "" {
+			if err := cfg.set(f, v); err != nil {
+				errs = append(errs, fmt.Errorf("%v variable: %w", f.env(), err))
+			}
+		}
+	}
+	for _, f := range fields() {
+		if v, ok := l.flags[f.key]; ok {
+			if err := cfg.set(f, v); err != nil {
+				errs = append(errs, fmt.Errorf("-%v flag: %w", f.flag(), err))
+			}
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	if len(errs) > 0 {
+		return Config{}, errs
+	}
+	return cfg, nil
+}
+
+// readFile decodes a YAML, JSON or TOML file over cfg, rejecting unknown keys
+func readFile(path string, cfg *Config) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(bs))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && err != io.EOF {
+			return fmt.Errorf("error reading %v: %w", path, err)
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(bs))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return fmt.Errorf("error reading %v: %w", path, err)
+		}
+	case ".toml":
+		if err := decodeTOML(bs, cfg); err != nil {
+			return fmt.Errorf("error reading %v: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("error reading %v: unknown format %q; should be .yaml, .yml, .json or .toml", path, ext)
+	}
+	return nil
+}
+
+// flagValue keeps the raw value of a setting flag until Load applies it
+type flagValue struct {
+	flags  map[string]string
+	key    string
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	if v.flags == nil {
+		return ""
+	}
+	return v.flags[v.key]
+}
+
+func (v *flagValue) Set(s string) error {
+	v.flags[v.key] = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
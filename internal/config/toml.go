@@ -0,0 +1,245 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// decodeTOML reads the subset of TOML the flat configuration needs over cfg: key = value lines with strings, integers, floats,
+// booleans and arrays of strings, and comments. Tables are rejected as there are no nested settings, and so are unknown keys
+func decodeTOML(bs []byte, cfg *Config) error {
+	byKey := make(map[string]field)
+	for _, f := range fields() {
+		byKey[f.key] = f
+	}
+	seen := make(map[string]bool)
+
+	sc := bufio.NewScanner(bytes.NewReader(bs))
+	for line := 0; sc.Scan(); {
+		line++
+		text, err := stripComment(sc.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		// arrays may span lines
+		start := line
+		for openBrackets(text) > 0 && !strings.HasPrefix(strings.TrimSpace(text), "[") && sc.Scan() {
+			line++
+			next, err := stripComment(sc.Text())
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			text += " " + next
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			return fmt.Errorf("line %d: tables are not supported, settings are top level keys", start)
+		}
+
+		key, raw, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("line %d: expected key = value", start)
+		}
+		key = strings.TrimSpace(key)
+		f, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("line %d: unknown key %q", start, key)
+		}
+		if seen[key] {
+			return fmt.Errorf("line %d: key %q is set twice", start, key)
+		}
+		seen[key] = true
+
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("line %d: %v: %w", start, key, err)
+		}
+		if err := cfg.setTOML(f, value); err != nil {
+			return fmt.Errorf("line %d: %v: %w", start, key, err)
+		}
+	}
+	return sc.Err()
+}
+
+// stripComment removes a comment that is not inside a string
+func stripComment(line string) (string, error) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && c == '#':
+			return line[:i], nil
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		}
+	}
+	if quote != 0 {
+		return "", errors.New("unterminated string")
+	}
+	return line, nil
+}
+
+// openBrackets returns the number of brackets outside strings that are not closed on the line, which has no comment
+func openBrackets(line string) int {
+	var quote byte
+	open := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && c == '[':
+			open++
+		case quote == 0 && c == ']':
+			open--
+		}
+	}
+	return open
+}
+
+// parseTOMLValue returns a string, int64, float64, bool or []string
+func parseTOMLValue(s string) (any, error) {
+	switch {
+	case s == "":
+		return nil, errors.New("missing value")
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s[0] == '"' || s[0] == '\'':
+		str, rest, err := parseTOMLString(s)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected %q after string", rest)
+		}
+		return str, nil
+	case s[0] == '[':
+		return parseTOMLArray(s)
+	}
+
+	number := strings.ReplaceAll(s, "_", "")
+	if i, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return i, nil
+	}
+	if x, err := strconv.ParseFloat(number, 64); err == nil {
+		return x, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", s)
+}
+
+// parseTOMLString parses a basic or literal string at the start of s and returns the rest
+func parseTOMLString(s string) (string, string, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			if quote == '\'' {
+				return s[1:i], s[i+1:], nil
+			}
+			str, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string %v", s[:i+1])
+			}
+			return str, s[i+1:], nil
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+func parseTOMLArray(s string) ([]string, error) {
+	rest := strings.TrimSpace(s[1:])
+	list := []string{}
+	for {
+		if strings.HasPrefix(rest, "]") {
+			if strings.TrimSpace(rest[1:]) != "" {
+				return nil, fmt.Errorf("unexpected %q after array", rest[1:])
+			}
+			return list, nil
+		}
+		if rest == "" || (rest[0] != '"' && rest[0] != '\'') {
+			return nil, errors.New("arrays should hold strings only")
+		}
+		item, after, err := parseTOMLString(rest)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+
+		rest = strings.TrimSpace(after)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, errors.New("array items should be separated by commas")
+		}
+	}
+}
+
+// setTOML sets the field from a decoded value of the matching type
+func (c *Config) setTOML(f field, value any) error {
+	v := reflect.ValueOf(c).Elem().Field(f.index)
+	if v.Addr().Type().Implements(textUnmarshalerType) {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("should be string, got %v", value)
+		}
+		return c.set(f, s)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("should be string, got %v", value)
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("should be true or false, got %v", value)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		i, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("should be integer, got %v", value)
+		}
+		v.SetInt(i)
+	case reflect.Float64:
+		switch x := value.(type) {
+		case int64:
+			v.SetFloat(float64(x))
+		case float64:
+			v.SetFloat(x)
+		default:
+			return fmt.Errorf("should be number, got %v", value)
+		}
+	case reflect.Slice:
+		list, ok := value.([]string)
+		if !ok {
+			return fmt.Errorf("should be array of strings, got %v", value)
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		panic("unsupported config field type " + v.Type().String())
+	}
+	return nil
+}
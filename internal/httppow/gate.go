@@ -0,0 +1,158 @@
+package httppow
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+)
+
+const (
+	// ChallengeHeader carries the challenge in the wire form in responses to requests without a valid solution
+	ChallengeHeader = "Pow-Challenge"
+	// SolutionHeader carries the quote request in the wire form, see protocol.QuoteRequest
+	SolutionHeader = "Pow-Solution"
+)
+
+// Gate passes requests with a valid solution in SolutionHeader to the next handler.
+// Other requests get 401 Unauthorized with a fresh challenge in ChallengeHeader
+type Gate struct {
+	next        http.Handler
+	complexity  int
+	noncePeriod time.Duration
+	key         ed25519.PrivateKey
+	serverID    string
+	logger      *log.Logger
+
+	nonces interface {
+		Current() uint64
+		Previous() uint64
+		Start(context.Context)
+	}
+}
+
+type Option func(*Gate)
+
+func WithComplexity(complexity int) Option {
+	return func(g *Gate) {
+		g.complexity = complexity
+	}
+}
+
+// WithNoncePeriod sets how often the server nonce changes, i.e. how long a client has to solve a puzzle
+func WithNoncePeriod(period time.Duration) Option {
+	return func(g *Gate) {
+		g.noncePeriod = period
+	}
+}
+
+// WithIdentityKey signs challenges with the key; a new key is generated by default
+func WithIdentityKey(key ed25519.PrivateKey) Option {
+	return func(g *Gate) {
+		g.key = key
+	}
+}
+
+// WithServerID sets the server id clients put in solutions, the key fingerprint by default
+func WithServerID(id string) Option {
+	return func(g *Gate) {
+		g.serverID = id
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(g *Gate) {
+		g.logger = logger
+	}
+}
+
+func NewGate(next http.Handler, opts ...Option) *Gate {
+	g := &Gate{
+		next:        next,
+		complexity:  5,
+		noncePeriod: time.Minute * 5,
+		logger:      log.Default(),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.key == nil {
+		_, g.key, _ = ed25519.GenerateKey(rand.Reader)
+	}
+	if g.serverID == "" {
+		g.serverID = puzzle.KeyFingerprint(g.PublicKey())
+	}
+	g.nonces = puzzle.NewNonceGenerator(g.noncePeriod)
+	return g
+}
+
+// PublicKey returns the key clients can verify challenges with
+func (g *Gate) PublicKey() ed25519.PublicKey {
+	return g.key.Public().(ed25519.PublicKey)
+}
+
+// Start changes the server nonce every nonce period until ctx is done; without it the nonce never changes
+func (g *Gate) Start(ctx context.Context) {
+	g.nonces.Start(ctx)
+}
+
+func (g *Gate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "client address is unknown", http.StatusBadRequest)
+		return
+	}
+	clientAddr := remote.Addr().Unmap()
+
+	solution := r.Header.Get(SolutionHeader)
+	if solution == "" {
+		g.challenge(w, clientAddr, "solve the challenge to access the resource")
+		return
+	}
+
+	req, err := protocol.ParseQuoteRequest([]byte(solution))
+	if err != nil {
+		g.logger.Printf("(%v) invalid solution header: %v", r.RemoteAddr, err)
+		g.challenge(w, clientAddr, "invalid solution header")
+		return
+	}
+
+	challenge := protocol.Challenge{
+		Nonce:      g.nonces.Current(),
+		Complexity: g.complexity,
+	}
+	// solving may take longer than what is left of the nonce period
+	if previous := g.nonces.Previous(); previous != 0 && req.NonceServer == previous {
+		challenge.Nonce = previous
+	}
+	peer := net.TCPAddrFromAddrPort(netip.AddrPortFrom(clientAddr, remote.Port()))
+	if err := puzzle.SolutionValidFor(challenge, puzzle.ServerIDs{g.serverID}, peer, req); err != nil {
+		g.logger.Printf("(%v) invalid solution: %v", r.RemoteAddr, err)
+		g.challenge(w, clientAddr, string(protocol.InvalidSolution))
+		return
+	}
+
+	r.Header.Del(SolutionHeader)
+	g.next.ServeHTTP(w, r)
+}
+
+// challenge responds with a fresh challenge for the client
+func (g *Gate) challenge(w http.ResponseWriter, clientAddr netip.Addr, msg string) {
+	challenge := protocol.Challenge{
+		Nonce:      g.nonces.Current(),
+		Complexity: g.complexity,
+		ClientAddr: clientAddr.String(),
+		ServerID:   g.serverID,
+	}
+	puzzle.SignChallenge(g.key, &challenge)
+
+	w.Header().Set(ChallengeHeader, string(challenge.Bytes()))
+	http.Error(w, msg, http.StatusUnauthorized)
+}
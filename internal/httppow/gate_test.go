@@ -0,0 +1,134 @@
+package httppow
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var discardLogger = log.New(io.Discard, "", 0)
+
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	_, _ = io.WriteString(w, "resource "+string(body))
+})
+
+func startGate(t *testing.T, opts ...Option) (*Gate, string) {
+	gate := NewGate(echoHandler, append([]Option{WithLogger(discardLogger)}, opts...)...)
+	srv := httptest.NewServer(gate)
+	t.Cleanup(srv.Close)
+	return gate, srv.URL
+}
+
+func get(t *testing.T, c *http.Client, req *http.Request) (int, string) {
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestGate(t *testing.T) {
+	gate, url := startGate(t, WithComplexity(2), WithServerID("api.example.com"))
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	challenge, err := protocol.ChallengeFromBytes([]byte(resp.Header.Get(ChallengeHeader)))
+	require.NoError(t, err)
+	assert.Equal(t, 2, challenge.Complexity)
+	assert.Equal(t, "127.0.0.1", challenge.ClientAddr)
+	assert.Equal(t, "api.example.com", challenge.ServerID)
+	assert.NoError(t, puzzle.VerifyChallenge(gate.PublicKey(), challenge))
+
+	solution := protocol.QuoteRequest{
+		ServerID: challenge.ServerID,
+		HashData: protocol.HashData{ClientID: challenge.ClientAddr, NonceServer: challenge.Nonce, NonceClient: 1},
+	}
+	puzzle.Solve(&solution.HashData, challenge)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set(SolutionHeader, string(solution.Bytes()))
+	status, body := get(t, http.DefaultClient, req)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "resource ", body)
+
+	status, body = get(t, http.DefaultClient, req)
+	assert.Equal(t, http.StatusUnauthorized, status, "replay")
+	assert.Equal(t, string(protocol.InvalidSolution)+"\n", body)
+
+	req.Header.Set(SolutionHeader, "garbage")
+	status, _ = get(t, http.DefaultClient, req)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestGate_NonceChange(t *testing.T) {
+	gate, url := startGate(t, WithComplexity(1), WithNoncePeriod(time.Millisecond*200))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gate.Start(ctx)
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	challenge, err := protocol.ChallengeFromBytes([]byte(resp.Header.Get(ChallengeHeader)))
+	require.NoError(t, err)
+	// the nonce changes once while solving
+	time.Sleep(time.Millisecond * 300)
+	require.NotEqual(t, challenge.Nonce, gate.nonces.Current())
+
+	solution := protocol.QuoteRequest{
+		ServerID: challenge.ServerID,
+		HashData: protocol.HashData{ClientID: challenge.ClientAddr, NonceServer: challenge.Nonce, NonceClient: 1},
+	}
+	puzzle.Solve(&solution.HashData, challenge)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set(SolutionHeader, string(solution.Bytes()))
+	status, _ := get(t, http.DefaultClient, req)
+	assert.Equal(t, http.StatusOK, status, "solution for the previous nonce")
+}
+
+func TestTransport(t *testing.T) {
+	gate, url := startGate(t, WithComplexity(2))
+	go gate.Start(context.Background())
+
+	c := &http.Client{Transport: &Transport{ServerKey: gate.PublicKey()}}
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader("body"))
+	require.NoError(t, err)
+	status, body := get(t, c, req)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "resource body", body, "body is replayed")
+
+	req, err = http.NewRequest(http.MethodPost, url, io.NopCloser(strings.NewReader("body")))
+	require.NoError(t, err)
+	status, _ = get(t, c, req)
+	assert.Equal(t, http.StatusUnauthorized, status, "body that cannot be replayed")
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	c.Transport = &Transport{ServerKey: otherPub}
+	_, err = c.Get(url)
+	assert.ErrorIs(t, err, puzzle.ErrBadSignature)
+
+	c.Transport = &Transport{Limits: puzzle.Limits{MaxComplexity: 1}}
+	_, err = c.Get(url)
+	assert.ErrorIs(t, err, puzzle.ErrChallengeTooHard)
+}
@@ -0,0 +1,101 @@
+package httppow
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+)
+
+// Transport solves challenges from a Gate and repeats the request with the solution.
+// Requests with a body are only repeated if the body can be replayed, see http.Request.GetBody
+type Transport struct {
+	// Base makes the actual requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+	// Limits refuses challenges that require more work than the client is ready to do
+	Limits puzzle.Limits
+	// ServerKey, if set, makes the transport solve only challenges signed by the gate
+	ServerKey ed25519.PublicKey
+	// ProgressInterval and OnProgress are passed to the solver, see puzzle.SolveContext
+	ProgressInterval time.Duration
+	OnProgress       func(protocol.Challenge, puzzle.Progress)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	challengeHeader := resp.Header.Get(ChallengeHeader)
+	if resp.StatusCode != http.StatusUnauthorized || challengeHeader == "" {
+		return resp, nil
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	challenge, err := protocol.ChallengeFromBytes([]byte(challengeHeader))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing challenge: %w", err)
+	}
+	solution, err := t.solve(req, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set(SolutionHeader, string(solution.Bytes()))
+	return t.base().RoundTrip(retry)
+}
+
+func (t *Transport) solve(req *http.Request, challenge protocol.Challenge) (protocol.QuoteRequest, error) {
+	if t.ServerKey != nil {
+		if err := puzzle.VerifyChallenge(t.ServerKey, challenge); err != nil {
+			return protocol.QuoteRequest{}, err
+		}
+	}
+	if err := t.Limits.Check(challenge); err != nil {
+		return protocol.QuoteRequest{}, fmt.Errorf("refusing challenge: %w", err)
+	}
+	if challenge.ClientAddr == "" || challenge.ServerID == "" {
+		return protocol.QuoteRequest{}, fmt.Errorf("challenge has no client address or server id: %v", challenge)
+	}
+
+	solution := protocol.QuoteRequest{
+		ServerID: challenge.ServerID,
+		HashData: protocol.HashData{
+			ClientID:    challenge.ClientAddr,
+			NonceServer: challenge.Nonce,
+			NonceClient: puzzle.GenerateNonceOnce(),
+		},
+	}
+
+	var onProgress func(puzzle.Progress)
+	if t.OnProgress != nil {
+		onProgress = func(p puzzle.Progress) {
+			t.OnProgress(challenge, p)
+		}
+	}
+	if _, err := puzzle.SolveContext(req.Context(), &solution.HashData, challenge, t.ProgressInterval, onProgress); err != nil {
+		return protocol.QuoteRequest{}, fmt.Errorf("error solving challenge: %w", err)
+	}
+	return solution, nil
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
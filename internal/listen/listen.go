@@ -0,0 +1,126 @@
+// Package listen opens listeners from addresses and inherits them from systemd socket activation
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const unixPrefix = "unix:"
+
+// Listen opens a stream listener: "unix:/path" for a unix socket, host:port for TCP.
+// A stale unix socket left by a previous run is removed first; a socket something still listens on is not
+func Listen(addr string) (net.Listener, error) {
+	path, ok := cutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("%q: unix socket path is empty", addr)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%q: unix socket is in use by another process", addr)
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// firstFD is the first descriptor passed by systemd, see sd_listen_fds(3)
+const firstFD = 3
+
+// Inherited is a socket passed by systemd, either a stream Listener or a datagram PacketConn
+type Inherited struct {
+	// Name is set with FileDescriptorName= in the socket unit, "unknown" by default
+	Name       string
+	Listener   net.Listener
+	PacketConn net.PacketConn
+}
+
+// Systemd returns the sockets passed by systemd socket activation, none if the process was not activated.
+// The LISTEN_* variables are unset so child processes do not inherit them
+func Systemd() ([]Inherited, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	count, fdNames, err := parseEnv(pid, fds, names, os.Getpid())
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	inherited := make([]Inherited, 0, count)
+	for i := 0; i < count; i++ {
+		f := os.NewFile(uintptr(firstFD+i), fdNames[i])
+		s, err := fromFile(f, fdNames[i])
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		inherited = append(inherited, s)
+	}
+	return inherited, nil
+}
+
+// fromFile wraps a copy of the descriptor, trying a stream socket first
+func fromFile(f *os.File, name string) (Inherited, error) {
+	s := Inherited{Name: name}
+	ln, lnErr := net.FileListener(f)
+	if lnErr == nil {
+		s.Listener = ln
+		return s, nil
+	}
+	pc, pcErr := net.FilePacketConn(f)
+	if pcErr == nil {
+		s.PacketConn = pc
+		return s, nil
+	}
+	return s, fmt.Errorf("inherited socket %q is neither a stream (%v) nor a datagram (%v) socket", name, lnErr, pcErr)
+}
+
+// parseEnv returns the number of passed descriptors and their names; zero if they are meant for another process
+func parseEnv(pid, fds, names string, getpid int) (int, []string, error) {
+	if fds == "" {
+		return 0, nil, nil
+	}
+	if p, err := strconv.Atoi(pid); err != nil || p != getpid {
+		return 0, nil, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return 0, nil, fmt.Errorf("LISTEN_FDS variable is incorrect: %q", fds)
+	}
+
+	fdNames := make([]string, count)
+	given := strings.Split(names, ":")
+	for i := range fdNames {
+		fdNames[i] = "unknown"
+		if names != "" && i < len(given) && given[i] != "" {
+			fdNames[i] = given[i]
+		}
+	}
+	return count, fdNames, nil
+}
+
+// cutPrefix is strings.CutPrefix, which needs a newer Go
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,102 @@
+package listen
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Equal(t, "tcp", ln.Addr().Network())
+	require.NoError(t, ln.Close())
+
+	_, err = Listen("unix:")
+	assert.Error(t, err)
+}
+
+func TestListen_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "powquote.sock")
+
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	// leave the socket file behind as a crashed process would
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	ln, err := Listen("unix:" + path)
+	require.NoError(t, err)
+	defer ln.Close()
+	assert.Equal(t, path, ln.Addr().String())
+
+	_, err = Listen("unix:" + path)
+	assert.ErrorContains(t, err, "in use", "a live socket is not taken over")
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path+".txt", nil, 0o600))
+	_, err = Listen("unix:" + path + ".txt")
+	assert.Error(t, err, "regular files are not removed")
+}
+
+func TestParseEnv(t *testing.T) {
+	tests := []struct {
+		name              string
+		pid, fds, fdNames string
+		count             int
+		names             []string
+		err               bool
+	}{
+		{name: "not activated"},
+		{name: "another process", pid: "2", fds: "1"},
+		{name: "unnamed", pid: "1", fds: "2", count: 2, names: []string{"unknown", "unknown"}},
+		{name: "named", pid: "1", fds: "3", fdNames: "quote:http", count: 3, names: []string{"quote", "http", "unknown"}},
+		{name: "invalid", pid: "1", fds: "x", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, names, err := parseEnv(tt.pid, tt.fds, tt.fdNames, 1)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.count, count)
+			if tt.count > 0 {
+				assert.Equal(t, tt.names, names)
+			}
+		})
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	s, err := fromFile(f, "quote")
+	require.NoError(t, err)
+	require.NotNil(t, s.Listener)
+	assert.Equal(t, ln.Addr().String(), s.Listener.Addr().String())
+	_ = s.Listener.Close()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	f, err = pc.(*net.UDPConn).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	s, err = fromFile(f, "udp")
+	require.NoError(t, err)
+	require.NotNil(t, s.PacketConn)
+	_ = s.PacketConn.Close()
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests by result.", "listener", "result")
+	c.Inc("tcp://:8080", "ok")
+	c.Add(2, "tcp://:8080", "ok")
+	c.Inc("http://:8081", "say \"hi\"\n")
+	r.GaugeFunc("difficulty", "Current difficulty.", func() float64 { return 5 })
+	r.CounterFunc("bytes_total", "Bytes.", []string{"dir"}, func() []Sample {
+		return []Sample{{Labels: []string{"out"}, Value: 10}, {Labels: []string{"in"}, Value: 1.5}}
+	})
+	h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP requests_total Requests by result.
+# TYPE requests_total counter
+requests_total{listener="http://:8081",result="say \"hi\"\n"} 1
+requests_total{listener="tcp://:8080",result="ok"} 3
+# HELP difficulty Current difficulty.
+# TYPE difficulty gauge
+difficulty 5
+# HELP bytes_total Bytes.
+# TYPE bytes_total counter
+bytes_total{dir="in"} 1.5
+bytes_total{dir="out"} 10
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.55
+latency_seconds_count 3
+`, buf.String())
+
+	assert.Panics(t, func() { c.Inc("only one") })
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.GaugeFunc("up", "Up.", func() float64 { return 1 })
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "up 1\n")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
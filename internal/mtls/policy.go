@@ -0,0 +1,132 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"powquote/internal/ratelimit"
+)
+
+// Policy is applied to clients with a verified certificate matching the rule
+type Policy struct {
+	// Exempt clients get the resource without the puzzle and rate limits
+	Exempt bool
+	// Complexity, if not nil, replaces the server complexity, e.g. to make the puzzle easier for partners
+	Complexity *int
+	// Rate, if PerSecond is set, replaces the IP and subnet rate limits with a quota shared by all connections with this certificate subject
+	Rate ratelimit.Rate
+
+	quota *ratelimit.Limiter[string]
+}
+
+// Allow takes a token from the subject quota; true if the policy has no quota
+func (p *Policy) Allow(subject string) bool {
+	if p.quota == nil {
+		return true
+	}
+	return p.quota.Allow(subject)
+}
+
+// HasQuota reports whether the policy replaces the default rate limits
+func (p *Policy) HasQuota() bool {
+	return p.quota != nil
+}
+
+// Rule is a single entry of the policies file; either Subject (as in "CN=partner,O=Acme") or CommonName is matched
+type Rule struct {
+	Subject    string  `json:"subject,omitempty"`
+	CommonName string  `json:"common_name,omitempty"`
+	Exempt     bool    `json:"exempt,omitempty"`
+	Complexity *int    `json:"complexity,omitempty"`
+	Rate       float64 `json:"rate,omitempty"`
+	Burst      int     `json:"burst,omitempty"`
+}
+
+// Policies maps client certificate subjects to policies
+type Policies struct {
+	bySubject    map[string]*Policy
+	byCommonName map[string]*Policy
+}
+
+func NewPolicies(rules []Rule) (*Policies, error) {
+	p := &Policies{
+		bySubject:    make(map[string]*Policy),
+		byCommonName: make(map[string]*Policy),
+	}
+	for i, rule := range rules {
+		if (rule.Subject == "") == (rule.CommonName == "") {
+			return nil, fmt.Errorf("rule %v: exactly one of subject and common_name must be set", i)
+		}
+		if rule.Complexity != nil && *rule.Complexity < 0 {
+			return nil, fmt.Errorf("rule %v: complexity must not be negative", i)
+		}
+		if rule.Rate < 0 {
+			return nil, fmt.Errorf("rule %v: rate must not be negative", i)
+		}
+
+		policy := &Policy{
+			Exempt:     rule.Exempt,
+			Complexity: rule.Complexity,
+			Rate:       ratelimit.Rate{PerSecond: rule.Rate, Burst: rule.Burst},
+		}
+		if rule.Rate > 0 {
+			policy.quota = ratelimit.NewLimiter[string](policy.Rate)
+		}
+
+		if rule.Subject != "" {
+			p.bySubject[rule.Subject] = policy
+		} else {
+			p.byCommonName[rule.CommonName] = policy
+		}
+	}
+	return p, nil
+}
+
+// Parse reads a JSON array of rules
+func Parse(r io.Reader) (*Policies, error) {
+	var rules []Rule
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return nil, err
+	}
+	return NewPolicies(rules)
+}
+
+func Load(path string) (*Policies, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return p, nil
+}
+
+var ErrNoClientCert = errors.New("no verified client certificate")
+
+// Lookup returns the subject of the verified client certificate and the policy for it.
+// The policy is nil if no rule matches
+func (p *Policies) Lookup(state tls.ConnectionState) (string, *Policy, error) {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", nil, ErrNoClientCert
+	}
+	return p.lookup(state.VerifiedChains[0][0])
+}
+
+func (p *Policies) lookup(cert *x509.Certificate) (string, *Policy, error) {
+	subject := cert.Subject.String()
+	if policy, ok := p.bySubject[subject]; ok {
+		return subject, policy, nil
+	}
+	return subject, p.byCommonName[cert.Subject.CommonName], nil
+}
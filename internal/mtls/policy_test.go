@@ -0,0 +1,74 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{name: "empty", rules: `[]`},
+		{name: "rules", rules: `[
+			{"subject": "CN=partner-a,O=Acme", "exempt": true},
+			{"common_name": "partner-b", "complexity": 3, "rate": 50, "burst": 100}
+		]`},
+		{name: "no match", rules: `[{"exempt": true}]`, err: "rule 0: exactly one of subject and common_name must be set"},
+		{name: "both matches", rules: `[{"subject": "CN=a", "common_name": "a"}]`, err: "rule 0: exactly one of subject and common_name must be set"},
+		{name: "negative complexity", rules: `[{"common_name": "a", "complexity": -1}]`, err: "rule 0: complexity must not be negative"},
+		{name: "unknown field", rules: `[{"common_name": "a", "exmpt": true}]`, err: `unknown field "exmpt"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.rules))
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPolicies_lookup(t *testing.T) {
+	p, err := Parse(strings.NewReader(`[
+		{"subject": "CN=partner-a,O=Acme", "exempt": true},
+		{"common_name": "partner-a", "complexity": 2},
+		{"common_name": "partner-b", "rate": 1, "burst": 1}
+	]`))
+	require.NoError(t, err)
+
+	cert := func(cn string, org ...string) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: cn, Organization: org}}
+	}
+
+	subject, policy, err := p.lookup(cert("partner-a", "Acme"))
+	require.NoError(t, err)
+	assert.Equal(t, "CN=partner-a,O=Acme", subject)
+	assert.True(t, policy.Exempt, "full subject wins")
+
+	_, policy, err = p.lookup(cert("partner-a", "Other"))
+	require.NoError(t, err)
+	assert.False(t, policy.Exempt)
+	assert.Equal(t, 2, *policy.Complexity)
+	assert.False(t, policy.HasQuota())
+	assert.True(t, policy.Allow("CN=partner-a,O=Other"))
+
+	subject, policy, err = p.lookup(cert("partner-b"))
+	require.NoError(t, err)
+	assert.True(t, policy.HasQuota())
+	assert.True(t, policy.Allow(subject))
+	assert.False(t, policy.Allow(subject), "quota is exhausted")
+
+	_, policy, err = p.lookup(cert("stranger"))
+	require.NoError(t, err)
+	assert.Nil(t, policy)
+}
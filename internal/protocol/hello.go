@@ -2,26 +2,80 @@ package protocol
 
 import (
 	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 )
 
 const separator = "--"
 
 var Hello = []byte("HELLO")
 
+// InvalidSolution is the server response to a quote request which solution was not accepted
+var InvalidSolution = []byte("invalid solution")
+
+// Overloaded is the server response when it has no capacity to handle the connection
+var Overloaded = []byte("server is overloaded, try again later")
+
+// RateLimited is the server response when the client connects too often
+var RateLimited = []byte("too many requests, slow down")
+
+// Accepted is the line the server sends before handing a single connection over to a proxied service
+var Accepted = []byte("accepted")
+
+// ResponseTooLarge is the UDP server response when the resource does not fit in a datagram
+var ResponseTooLarge = []byte("response too large for a datagram")
+
+const (
+	// HelloDatagramSize is the size UDP clients pad requests to with zero bytes after a line break.
+	// A UDP server never answers an unverified request with more bytes than it has received, so the challenge has to fit
+	HelloDatagramSize = 512
+	// MaxDatagramSize is the largest UDP response a server sends, small enough to avoid IP fragmentation on most paths
+	MaxDatagramSize = 1232
+)
+
 type ChallengeRequest struct{}
 
 type Challenge struct {
 	Nonce      uint64
 	Complexity int
+	// ClientAddr is the client IP as observed by the server, to be used as HashData.ClientID.
+	// It is optional, older servers do not send it
+	ClientAddr string
+	// ServerID is the server identity to be used as QuoteRequest.ServerID.
+	// It is optional, older servers expect the address the client has connected to
+	ServerID string
+	// Signature authenticates all the fields above, see puzzle.SignChallenge
+	Signature []byte
 }
 
 func ChallengeFromBytes(bs []byte) (c Challenge, err error) {
 	parts := bytes.SplitN(bs, []byte(separator), 2)
+	if len(parts) != 2 {
+		return c, fmt.Errorf("invalid challenge %q", bs)
+	}
 	c.Nonce, err = strconv.ParseUint(string(parts[0]), 10, 64)
 	if err != nil {
 		return
 	}
+
+	// extended challenge carries the client address, optionally the server id, and the signature after the complexity
+	rest := bytes.SplitN(parts[1], []byte(separator), 4)
+	if len(rest) >= 3 {
+		parts[1] = rest[0]
+		c.ClientAddr = string(rest[1])
+		sig := rest[len(rest)-1]
+		if len(rest) == 4 {
+			c.ServerID = string(rest[2])
+		}
+		c.Signature, err = base64.StdEncoding.DecodeString(string(sig))
+		if err != nil {
+			return
+		}
+	}
+
 	complexity, err := strconv.ParseInt(string(parts[1]), 10, 64)
 	if err != nil {
 		return
@@ -30,10 +84,43 @@ func ChallengeFromBytes(bs []byte) (c Challenge, err error) {
 	return c, nil
 }
 
-func (c Challenge) Bytes() []byte {
+// SignedBytes returns the part of the challenge covered by the signature
+func (c Challenge) SignedBytes() []byte {
 	var bs []byte
 	bs = append(bs, strconv.FormatUint(c.Nonce, 10)...)
 	bs = append(bs, separator...)
 	bs = append(bs, strconv.FormatInt(int64(c.Complexity), 10)...)
+	if c.extended() {
+		bs = append(bs, separator...)
+		bs = append(bs, c.ClientAddr...)
+	}
+	if c.ServerID != "" {
+		bs = append(bs, separator...)
+		bs = append(bs, c.ServerID...)
+	}
+	return bs
+}
+
+func (c Challenge) extended() bool {
+	return c.ClientAddr != "" || c.ServerID != "" || c.Signature != nil
+}
+
+func (c Challenge) Bytes() []byte {
+	bs := c.SignedBytes()
+	if c.extended() {
+		bs = append(bs, separator...)
+		bs = append(bs, base64.StdEncoding.EncodeToString(c.Signature)...)
+	}
 	return bs
 }
+
+// ValidServerID checks that the server id can be sent in a challenge and a quote request
+func ValidServerID(id string) error {
+	if id == "" {
+		return errors.New("server id is empty")
+	}
+	if strings.Contains(id, separator) || strings.ContainsAny(id, "\r\n") {
+		return fmt.Errorf("server id %q must not contain %q or line breaks", id, separator)
+	}
+	return nil
+}
@@ -35,6 +35,35 @@ func TestChallengeFromBytes(t *testing.T) {
 			},
 			err: assert.NoError,
 		},
+		{
+			challenge: []byte("111--222--10.0.0.1--eHl6"),
+			want: Challenge{
+				Nonce:      111,
+				Complexity: 222,
+				ClientAddr: "10.0.0.1",
+				Signature:  []byte("xyz"),
+			},
+			err: assert.NoError,
+		},
+		{
+			challenge: []byte("111--222--10.0.0.1--quotes.example.com--eHl6"),
+			want: Challenge{
+				Nonce:      111,
+				Complexity: 222,
+				ClientAddr: "10.0.0.1",
+				ServerID:   "quotes.example.com",
+				Signature:  []byte("xyz"),
+			},
+			err: assert.NoError,
+		},
+		{
+			challenge: []byte("111--222--10.0.0.1--%%%"),
+			err: ErrorLike(`illegal base64 data`),
+		},
+		{
+			challenge: []byte("111"),
+			err: ErrorLike(`invalid challenge "111"`),
+		},
 		{
 			challenge: []byte("111--222--333"),
 			err: ErrorLike(`strconv.ParseInt: parsing "222--333": invalid syntax`),
@@ -85,6 +114,25 @@ func TestChallenge_Bytes(t *testing.T) {
 			},
 			want: []byte("18446744073709551615--9223372036854775807"),
 		},
+		{
+			ch: Challenge{
+				Nonce:      111,
+				Complexity: 222,
+				ClientAddr: "2001:db8::1",
+				Signature:  []byte("xyz"),
+			},
+			want: []byte("111--222--2001:db8::1--eHl6"),
+		},
+		{
+			ch: Challenge{
+				Nonce:      111,
+				Complexity: 222,
+				ClientAddr: "2001:db8::1",
+				ServerID:   "quotes.example.com",
+				Signature:  []byte("xyz"),
+			},
+			want: []byte("111--222--2001:db8::1--quotes.example.com--eHl6"),
+		},
 	}
 	for name, tt := range tests {
 		t.Run(strconv.Itoa(name), func(t *testing.T) {
@@ -92,3 +140,11 @@ func TestChallenge_Bytes(t *testing.T) {
 		})
 	}
 }
+
+func TestValidServerID(t *testing.T) {
+	assert.NoError(t, ValidServerID("quotes.example.com"))
+	assert.NoError(t, ValidServerID("10.0.0.1:9999"))
+	assert.Error(t, ValidServerID(""))
+	assert.Error(t, ValidServerID("quotes--example"))
+	assert.Error(t, ValidServerID("quotes\n"))
+}
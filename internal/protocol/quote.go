@@ -9,6 +9,8 @@ import (
 
 const (
 	maxSolutionLength = 1024 * 64
+	// MaxRequestLength is the longest request line a server has to accept, line break included
+	MaxRequestLength = maxSolutionLength + 1
 )
 
 const (
@@ -0,0 +1,275 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY protocol v1 and v2,
+// see https://www.haproxy.org/download/2.6/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	v1MaxLength = 107
+	v2HeaderLen = 16
+)
+
+var (
+	ErrNoHeader = errors.New("no PROXY protocol header")
+	// ErrUntrusted is returned for connections from peers that are not trusted proxies when the header is required
+	ErrUntrusted = errors.New("peer is not a trusted proxy")
+)
+
+// Header is the connection information passed by the proxy.
+// Source and Destination are nil for LOCAL connections (e.g. proxy health checks) and unknown protocols
+type Header struct {
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// Listener wraps accepted connections so that their RemoteAddr and LocalAddr are the ones reported by the proxy.
+// The header is read on the first use of the connection, so a slow proxy does not block Accept
+type Listener struct {
+	net.Listener
+	// HeaderTimeout limits reading the header; zero means no limit
+	HeaderTimeout time.Duration
+	// Optional makes connections without a header pass through with their own addresses, otherwise they fail
+	Optional bool
+	// Trusted are the proxies allowed to send a header, none when empty.
+	// The header of other peers is not read: they fail, or pass through with their own addresses if the header is optional
+	Trusted []netip.Prefix
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, r: bufio.NewReader(conn), timeout: l.HeaderTimeout, optional: l.Optional, untrusted: !l.trusts(conn.RemoteAddr())}, nil
+}
+
+func (l *Listener) trusts(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, prefix := range l.Trusted {
+		if prefix.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+type Conn struct {
+	net.Conn
+	r        *bufio.Reader
+	timeout  time.Duration
+	optional bool
+	// untrusted peers may not send a header
+	untrusted bool
+
+	once   sync.Once
+	header Header
+	err    error
+}
+
+// Handshake reads the PROXY protocol header if it has not been read yet
+func (c *Conn) Handshake() error {
+	_, err := c.Header()
+	return err
+}
+
+// Header reads the PROXY protocol header if it has not been read yet
+func (c *Conn) Header() (Header, error) {
+	c.once.Do(func() {
+		if c.untrusted {
+			if !c.optional {
+				c.err = ErrUntrusted
+			}
+			return
+		}
+		if c.timeout > 0 {
+			if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+				c.err = err
+				return
+			}
+			defer func() {
+				_ = c.Conn.SetReadDeadline(time.Time{})
+			}()
+		}
+		c.header, c.err = ReadHeader(c.r)
+		if errors.Is(c.err, ErrNoHeader) && c.optional {
+			c.err = nil
+		}
+	})
+	return c.header, c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ReadHeader reads a v1 or v2 header from r; ErrNoHeader is returned without consuming anything if there is none
+func ReadHeader(r *bufio.Reader) (Header, error) {
+	if ok, err := hasPrefix(r, v2Signature); err != nil {
+		return Header{}, err
+	} else if ok {
+		return readV2(r)
+	}
+	if ok, err := hasPrefix(r, v1Prefix); err != nil {
+		return Header{}, err
+	} else if ok {
+		return readV1(r)
+	}
+	return Header{}, ErrNoHeader
+}
+
+// hasPrefix peeks byte by byte, so a client without a header that sent something short is not kept waiting for more
+func hasPrefix(r *bufio.Reader, prefix []byte) (bool, error) {
+	for n := 1; n <= len(prefix); n++ {
+		peek, err := r.Peek(n)
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if peek[n-1] != prefix[n-1] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func readV1(r *bufio.Reader) (Header, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return Header{}, fmt.Errorf("reading v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= v1MaxLength {
+			return Header{}, errors.New("v1 header is too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return Header{}, errors.New("v1 header must end with CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return Header{}, nil
+	}
+	if len(fields) != 6 {
+		return Header{}, fmt.Errorf("invalid v1 header %q", line)
+	}
+	if fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return Header{}, fmt.Errorf("unsupported v1 protocol %q", fields[1])
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return Header{}, fmt.Errorf("v1 source: %w", err)
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return Header{}, fmt.Errorf("v1 destination: %w", err)
+	}
+	return Header{Source: src, Destination: dst}, nil
+}
+
+func parseV1Addr(ip, port string) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+func readV2(r *bufio.Reader) (Header, error) {
+	var fixed [v2HeaderLen]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return Header{}, fmt.Errorf("reading v2 header: %w", err)
+	}
+
+	verCmd, famProto := fixed[12], fixed[13]
+	if verCmd>>4 != 2 {
+		return Header{}, fmt.Errorf("unsupported v2 version %v", verCmd>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Header{}, fmt.Errorf("reading v2 addresses: %w", err)
+	}
+
+	switch verCmd & 0xF {
+	case 0x0: // LOCAL
+		return Header{}, nil
+	case 0x1: // PROXY
+	default:
+		return Header{}, fmt.Errorf("unsupported v2 command %v", verCmd&0xF)
+	}
+
+	// only TCP (stream) over IPv4 and IPv6 carry addresses we use
+	var ipLen int
+	switch famProto {
+	case 0x11:
+		ipLen = 4
+	case 0x21:
+		ipLen = 16
+	default:
+		return Header{}, nil
+	}
+	if len(body) < ipLen*2+4 {
+		return Header{}, errors.New("v2 address block is too short")
+	}
+
+	srcIP, _ := netip.AddrFromSlice(body[:ipLen])
+	dstIP, _ := netip.AddrFromSlice(body[ipLen : ipLen*2])
+	srcPort := binary.BigEndian.Uint16(body[ipLen*2:])
+	dstPort := binary.BigEndian.Uint16(body[ipLen*2+2:])
+
+	return Header{
+		Source:      net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort)),
+		Destination: net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort)),
+	}, nil
+}
@@ -0,0 +1,215 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func v2Header(cmd byte, famProto byte, addrs []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(v2Signature)
+	buf.WriteByte(0x20 | cmd)
+	buf.WriteByte(famProto)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes()
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		src   string
+		dst   string
+		rest  string
+		err   string
+	}{
+		{
+			name:  "v1 tcp4",
+			input: []byte("PROXY TCP4 203.0.113.7 192.0.2.1 5555 9999\r\nHELLO\n"),
+			src:   "203.0.113.7:5555",
+			dst:   "192.0.2.1:9999",
+			rest:  "HELLO\n",
+		},
+		{
+			name:  "v1 tcp6",
+			input: []byte("PROXY TCP6 2001:db8::7 2001:db8::1 5555 9999\r\n"),
+			src:   "[2001:db8::7]:5555",
+			dst:   "[2001:db8::1]:9999",
+		},
+		{
+			name:  "v1 unknown",
+			input: []byte("PROXY UNKNOWN\r\nHELLO\n"),
+			rest:  "HELLO\n",
+		},
+		{
+			name:  "v1 without CRLF",
+			input: []byte("PROXY TCP4 203.0.113.7 192.0.2.1 5555 9999\nHELLO\n"),
+			err:   "v1 header must end with CRLF",
+		},
+		{
+			name:  "v1 too long",
+			input: []byte("PROXY " + strings.Repeat("x", 200)),
+			err:   "v1 header is too long",
+		},
+		{
+			name:  "v1 bad address",
+			input: []byte("PROXY TCP4 203.0.113 192.0.2.1 5555 9999\r\n"),
+			err:   "v1 source",
+		},
+		{
+			name:  "v2 tcp4",
+			input: append(v2Header(0x1, 0x11, []byte{203, 0, 113, 7, 192, 0, 2, 1, 0x15, 0xb3, 0x27, 0x0f}), "HELLO\n"...),
+			src:   "203.0.113.7:5555",
+			dst:   "192.0.2.1:9999",
+			rest:  "HELLO\n",
+		},
+		{
+			name: "v2 tcp6 with TLVs",
+			input: v2Header(0x1, 0x21, append(append(append(
+				net.ParseIP("2001:db8::7").To16(),
+				net.ParseIP("2001:db8::1").To16()...),
+				0x15, 0xb3, 0x27, 0x0f),
+				0x04, 0x00, 0x01, 0xff)),
+			src: "[2001:db8::7]:5555",
+			dst: "[2001:db8::1]:9999",
+		},
+		{
+			name:  "v2 local",
+			input: append(v2Header(0x0, 0x00, nil), "HELLO\n"...),
+			rest:  "HELLO\n",
+		},
+		{
+			name:  "v2 truncated",
+			input: v2Header(0x1, 0x11, []byte{203, 0, 113, 7, 192, 0, 2, 1, 0x15, 0xb3, 0x27, 0x0f})[:20],
+			err:   "reading v2 addresses",
+		},
+		{
+			name:  "no header",
+			input: []byte("HELLO\n"),
+			rest:  "HELLO\n",
+			err:   "no PROXY protocol header",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.input))
+			h, err := ReadHeader(r)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+				if tt.src == "" {
+					assert.Nil(t, h.Source)
+					assert.Nil(t, h.Destination)
+				} else {
+					assert.Equal(t, tt.src, h.Source.String())
+					assert.Equal(t, tt.dst, h.Destination.String())
+				}
+			}
+			if tt.rest != "" {
+				rest, _ := io.ReadAll(r)
+				assert.Equal(t, tt.rest, string(rest))
+			}
+		})
+	}
+}
+
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	pln := &Listener{Listener: ln, HeaderTimeout: time.Second, Trusted: loopback}
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.1 5555 9999\r\nHELLO\n"))
+	require.NoError(t, err)
+
+	conn, err := pln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, "203.0.113.7:5555", conn.RemoteAddr().String())
+	assert.Equal(t, "192.0.2.1:9999", conn.LocalAddr().String())
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO\n", line)
+}
+
+func TestListener_Optional(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	for _, optional := range []bool{true, false} {
+		pln := &Listener{Listener: ln, HeaderTimeout: time.Second, Optional: optional, Trusted: loopback}
+
+		client, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		_, err = client.Write([]byte("HELLO\n"))
+		require.NoError(t, err)
+
+		conn, err := pln.Accept()
+		require.NoError(t, err)
+
+		if optional {
+			assert.NoError(t, conn.(*Conn).Handshake())
+			assert.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+		} else {
+			assert.ErrorIs(t, conn.(*Conn).Handshake(), ErrNoHeader)
+		}
+		_ = conn.Close()
+		_ = client.Close()
+	}
+}
+
+func TestListener_Untrusted(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// without trusted proxies no peer is
+	for _, trusted := range [][]netip.Prefix{{netip.MustParsePrefix("10.0.0.0/8")}, nil} {
+		for _, optional := range []bool{true, false} {
+			testUntrusted(t, &Listener{Listener: ln, HeaderTimeout: time.Second, Optional: optional, Trusted: trusted})
+		}
+	}
+}
+
+func testUntrusted(t *testing.T, pln *Listener) {
+	client, err := net.Dial("tcp", pln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.1 5555 9999\r\nHELLO\n"))
+	require.NoError(t, err)
+
+	conn, err := pln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	if pln.Optional {
+		assert.NoError(t, conn.(*Conn).Handshake())
+		assert.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String(), "spoofed address is ignored")
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "PROXY TCP4 203.0.113.7 192.0.2.1 5555 9999\r\n", line, "header is passed on as data")
+	} else {
+		assert.ErrorIs(t, conn.(*Conn).Handshake(), ErrUntrusted)
+	}
+}
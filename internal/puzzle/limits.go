@@ -0,0 +1,52 @@
+package puzzle
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"powquote/internal/protocol"
+)
+
+var ErrChallengeTooHard = errors.New("challenge is too hard")
+
+// Limits describes the maximum amount of work a client agrees to do for a challenge.
+// Zero values mean no limit.
+type Limits struct {
+	MaxComplexity int
+	MaxDuration   time.Duration
+	// HashRate is the local hash rate used to estimate solving time against MaxDuration, see Benchmark
+	HashRate float64
+}
+
+func (l Limits) Check(challenge protocol.Challenge) error {
+	if l.MaxComplexity > 0 && challenge.Complexity > l.MaxComplexity {
+		return fmt.Errorf("%w: complexity %v exceeds maximum %v", ErrChallengeTooHard, challenge.Complexity, l.MaxComplexity)
+	}
+	if l.MaxDuration > 0 {
+		if expected := ExpectedDuration(challenge.Complexity, l.HashRate); expected > l.MaxDuration {
+			return fmt.Errorf("%w: complexity %v would take ~%v at %.0f hashes/s, maximum is %v", ErrChallengeTooHard, challenge.Complexity, expected.Round(time.Second), l.HashRate, l.MaxDuration)
+		}
+	}
+	return nil
+}
+
+// Benchmark measures the local hash rate in hashes per second by hashing for the given duration
+func Benchmark(d time.Duration) float64 {
+	hashData := protocol.HashData{
+		ClientID: "127.0.0.1",
+		Solution: make([]byte, 128),
+	}
+	impossible := protocol.Challenge{Complexity: len(Hash(&hashData)) + 1}
+
+	started := time.Now()
+	var attempts uint64
+	for time.Since(started) < d {
+		for i := 0; i < progressCheckEvery; i++ {
+			hashData.NonceClient++
+			HashMatchesChallenge(Hash(&hashData), impossible)
+		}
+		attempts += progressCheckEvery
+	}
+	return float64(attempts) / time.Since(started).Seconds()
+}
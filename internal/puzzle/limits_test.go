@@ -0,0 +1,68 @@
+package puzzle
+
+import (
+	"testing"
+	"time"
+
+	"powquote/internal/protocol"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits_Check(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    Limits
+		challenge protocol.Challenge
+		err       assert.ErrorAssertionFunc
+	}{
+		{
+			name:      "no limits",
+			limits:    Limits{},
+			challenge: protocol.Challenge{Complexity: 40},
+			err:       assert.NoError,
+		},
+		{
+			name:      "complexity within limit",
+			limits:    Limits{MaxComplexity: 6},
+			challenge: protocol.Challenge{Complexity: 6},
+			err:       assert.NoError,
+		},
+		{
+			name:      "complexity above limit",
+			limits:    Limits{MaxComplexity: 6},
+			challenge: protocol.Challenge{Complexity: 7},
+			err:       ErrorLike(`challenge is too hard: complexity 7 exceeds maximum 6`),
+		},
+		{
+			name:      "expected duration within limit",
+			limits:    Limits{MaxDuration: time.Second, HashRate: 256},
+			challenge: protocol.Challenge{Complexity: 2},
+			err:       assert.NoError,
+		},
+		{
+			name:      "expected duration above limit",
+			limits:    Limits{MaxDuration: time.Second, HashRate: 256},
+			challenge: protocol.Challenge{Complexity: 3},
+			err:       ErrorLike(`challenge is too hard: complexity 3 would take ~16s at 256 hashes/s, maximum is 1s`),
+		},
+		{
+			name:      "duration limit without hash rate refuses everything",
+			limits:    Limits{MaxDuration: time.Second},
+			challenge: protocol.Challenge{Complexity: 1},
+			err:       assert.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Check(tt.challenge)
+			if tt.err(t, err) && err != nil {
+				assert.ErrorIs(t, err, ErrChallengeTooHard)
+			}
+		})
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	assert.Greater(t, Benchmark(time.Millisecond*50), float64(0))
+}
@@ -21,9 +21,16 @@ func GenerateNonceOnce() uint64 {
 }
 
 func NewNonceGenerator(period time.Duration) *nonceGenerator {
-	return &nonceGenerator{
+	n := &nonceGenerator{
 		period: period,
 	}
+	n.tick()
+	return n
+}
+
+// nonces are the current server nonce and the one it replaced
+type nonces struct {
+	current, previous uint64
 }
 
 func (n *nonceGenerator) tick() {
@@ -31,19 +38,33 @@ func (n *nonceGenerator) tick() {
 	if err != nil {
 		panic(err)
 	}
-	n.value.Store(v.Uint64())
+	old, _ := n.value.Load().(nonces)
+	n.value.Store(nonces{current: v.Uint64(), previous: old.current})
+	if old.previous != 0 {
+		// solutions for it are not accepted anymore, so they need not be remembered
+		forgetAttempts(old.previous)
+	}
 }
 func (n *nonceGenerator) Current() uint64 {
-	if v, ok := n.value.Load().(uint64); !ok || v == 0 {
+	if v, ok := n.value.Load().(nonces); !ok || v.current == 0 {
 		n.tick()
 	}
-	return n.value.Load().(uint64)
+	return n.value.Load().(nonces).current
 }
 
-func (n *nonceGenerator) Start(ctx context.Context) {
-	ticker := time.NewTicker(n.period)
+// Previous returns the nonce before the last change, so solutions started just before it can still be accepted; zero if there is none
+func (n *nonceGenerator) Previous() uint64 {
+	v, _ := n.value.Load().(nonces)
+	return v.previous
+}
 
+// Rotate replaces the current nonce right away, the replaced one becomes the previous
+func (n *nonceGenerator) Rotate() {
 	n.tick()
+}
+
+func (n *nonceGenerator) Start(ctx context.Context) {
+	ticker := time.NewTicker(n.period)
 
 	for {
 		select {
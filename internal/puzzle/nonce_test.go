@@ -26,6 +26,28 @@ func TestNonceGenerator(t *testing.T) {
 	assert.NotEqual(t, v4, 0, "v4 != 0")
 	assert.NotEqual(t, v1, v4, "v1 != v4")
 	assert.Equal(t, v4, v5, "v4 == v5")
+	assert.NotEqual(t, uint64(0), gen.Previous())
+	assert.NotEqual(t, v4, gen.Previous())
+}
+
+func TestNonceGenerator_ForgetsAttempts(t *testing.T) {
+	gen := NewNonceGenerator(time.Hour)
+	first := gen.Current()
+	attempt := solutionAttempt{clientID: "127.0.0.1", nonceServer: first, nonceClient: 1}
+	attemptsMutex.Lock()
+	solutionAttempts[attempt] = struct{}{}
+	attemptsMutex.Unlock()
+	has := func() bool {
+		attemptsMutex.Lock()
+		defer attemptsMutex.Unlock()
+		_, ok := solutionAttempts[attempt]
+		return ok
+	}
+
+	gen.tick()
+	assert.True(t, has(), "solutions for the previous nonce are still accepted")
+	gen.tick()
+	assert.False(t, has(), "solutions for older nonces are forgotten")
 }
 
 func TestGenerateNonceOnce(t *testing.T) {
@@ -4,46 +4,84 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
-	"os"
-	"strconv"
 
 	"powquote/internal/protocol"
 )
 
-func ProtectionEnabled() bool {
-	protected := os.Getenv("PROTECTED")
-	if protected == "" {
-		return true
-	}
-	value, err := strconv.ParseBool(protected)
-	if err != nil {
-		return true
-	}
-	return value
-}
+var (
+	ErrRequestTooLarge = errors.New("request too large")
+	// ErrMalformedRequest is a line that is neither HELLO nor a quote request
+	ErrMalformedRequest = errors.New("malformed request")
+)
 
 func ReadRequest(r io.Reader) (any, error) {
-	sc := bufio.NewScanner(r)
-	for sc.Scan() {
-		token := sc.Bytes()
-		if len(token) == 0 {
-			continue
-		}
+	return ReadRequestMax(r, protocol.MaxRequestLength)
+}
 
-		if bytes.EqualFold(token, protocol.Hello) {
-			return protocol.ChallengeRequest{}, nil
+// ReadRequestMax works like ReadRequest but fails with ErrRequestTooLarge once more than max bytes, blank lines included, are read without a complete request.
+// Reads are buffered; pass a *bufio.Reader to keep whatever the client sent after the request line in it
+func ReadRequestMax(r io.Reader, max int) (any, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	for read := 0; ; {
+		if read >= max {
+			return nil, ErrRequestTooLarge
+		}
+		line, err := readLine(br, max-read)
+		read += len(line)
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			// an unterminated remainder at the end of the input
+			err = nil
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("invalid request")
 		}
-
-		quoteRequest, err := protocol.ParseQuoteRequest(token)
 		if err != nil {
 			return nil, err
 		}
-		return quoteRequest, nil
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if len(line) > 0 {
+			return parseRequest(line)
+		}
 	}
-	if sc.Err() != nil {
-		return nil, sc.Err()
+}
+
+// readLine reads up to and including the next line break from br, but no more than max bytes, and leaves the rest buffered
+func readLine(br *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		if _, err := br.Peek(1); err != nil {
+			return line, err
+		}
+		buf, _ := br.Peek(br.Buffered())
+		if left := max - len(line); len(buf) > left {
+			buf = buf[:left]
+		}
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			buf = buf[:i+1]
+			line = append(line, buf...)
+			_, _ = br.Discard(len(buf))
+			return line, nil
+		}
+		line = append(line, buf...)
+		_, _ = br.Discard(len(buf))
+		if len(line) >= max {
+			return line, ErrRequestTooLarge
+		}
 	}
+}
 
-	return nil, errors.New("invalid request")
+func parseRequest(line []byte) (any, error) {
+	if bytes.EqualFold(line, protocol.Hello) {
+		return protocol.ChallengeRequest{}, nil
+	}
+	quoteRequest, err := protocol.ParseQuoteRequest(line)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedRequest, err)
+	}
+	return quoteRequest, nil
 }
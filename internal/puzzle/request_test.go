@@ -1,6 +1,7 @@
 package puzzle
 
 import (
+	"bufio"
 	"io"
 	"strconv"
 	"strings"
@@ -50,7 +51,9 @@ func TestReadRequest(t *testing.T) {
 		},
 		{
 			reader: strings.NewReader(`10.0.0.1:9999--10.1.0.1`),
-			err: assert.Error,
+			err: func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+				return assert.ErrorIs(t, err, ErrMalformedRequest, msgAndArgs...)
+			},
 		},
 		{
 			reader: strings.NewReader(""),
@@ -70,3 +73,48 @@ func TestReadRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestReadRequestMax(t *testing.T) {
+	tests := []struct {
+		reader io.Reader
+		want   any
+		err    assert.ErrorAssertionFunc
+	}{
+		{
+			reader: strings.NewReader("\n\nHELLO\n"),
+			want:   protocol.ChallengeRequest{},
+			err:    assert.NoError,
+		},
+		{
+			reader: strings.NewReader(strings.Repeat("\n", 100) + "HELLO\n"),
+			err:    ErrorLike(`request too large`),
+		},
+		{
+			reader: strings.NewReader(strings.Repeat("a", 100)),
+			err:    ErrorLike(`request too large`),
+		},
+		{
+			reader: strings.NewReader("\n\n\n" + strings.Repeat("a", 100)),
+			err:    ErrorLike(`request too large`),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(strconv.Itoa(name), func(t *testing.T) {
+			got, err := ReadRequestMax(tt.reader, 16)
+			if tt.err(t, err) && err == nil {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReadRequestMax_Pipelined(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("HELLO\r\npipelined"))
+	got, err := ReadRequestMax(r, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ChallengeRequest{}, got)
+
+	rest, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "pipelined", string(rest))
+}
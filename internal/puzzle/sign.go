@@ -0,0 +1,30 @@
+package puzzle
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"powquote/internal/protocol"
+)
+
+var ErrBadSignature = errors.New("challenge signature is invalid")
+
+// SignChallenge lets clients make sure the challenge, and the client address in it, come from the server
+func SignChallenge(key ed25519.PrivateKey, c *protocol.Challenge) {
+	c.Signature = ed25519.Sign(key, c.SignedBytes())
+}
+
+func VerifyChallenge(pub ed25519.PublicKey, c protocol.Challenge) error {
+	if len(c.Signature) != ed25519.SignatureSize || !ed25519.Verify(pub, c.SignedBytes(), c.Signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// KeyFingerprint is a short stable name of the key, suitable as a server id
+func KeyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "ed25519-" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,47 @@
+package puzzle
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"powquote/internal/protocol"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignChallenge(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	c := protocol.Challenge{
+		Nonce:      111,
+		Complexity: 5,
+		ClientAddr: "172.18.0.3",
+	}
+	SignChallenge(key, &c)
+
+	parsed, err := protocol.ChallengeFromBytes(c.Bytes())
+	require.NoError(t, err)
+	assert.NoError(t, VerifyChallenge(pub, parsed))
+	assert.ErrorIs(t, VerifyChallenge(otherPub, parsed), ErrBadSignature)
+
+	spoofed := parsed
+	spoofed.ClientAddr = "172.18.0.4"
+	assert.ErrorIs(t, VerifyChallenge(pub, spoofed), ErrBadSignature)
+
+	easier := parsed
+	easier.Complexity = 1
+	assert.ErrorIs(t, VerifyChallenge(pub, easier), ErrBadSignature)
+
+	unsigned := protocol.Challenge{Nonce: 111, Complexity: 5}
+	assert.ErrorIs(t, VerifyChallenge(pub, unsigned), ErrBadSignature)
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	pub := ed25519.PublicKey(make([]byte, ed25519.PublicKeySize))
+	assert.Equal(t, "ed25519-66687aadf862bd776c8fc18b8e9f8e20", KeyFingerprint(pub))
+	assert.NoError(t, protocol.ValidServerID(KeyFingerprint(pub)))
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"strconv"
+	"strings"
 	"sync"
 
 	"powquote/internal/protocol"
@@ -19,27 +21,83 @@ type solutionAttempt struct {
 	nonceClient uint64
 }
 
+var (
+	ErrReplay      = errors.New("attempt exist")
+	ErrInvalidHash = errors.New("invalid hash solution")
+	// ErrServerID, ErrClientID and ErrNonce mean the solution is for another server, client or server nonce
+	ErrServerID = errors.New("server id")
+	ErrClientID = errors.New("client addr")
+	ErrNonce    = errors.New("server nonce")
+)
+
 var solutionAttempts = make(map[solutionAttempt]struct{})
 var attemptsMutex sync.Mutex
 
-func stripPort(addr net.Addr) string {
+// ReplayStoreSize returns the number of accepted solutions remembered for replay protection
+func ReplayStoreSize() int {
+	attemptsMutex.Lock()
+	defer attemptsMutex.Unlock()
+	return len(solutionAttempts)
+}
+
+// forgetAttempts drops the accepted solutions for a server nonce that is no longer in use
+func forgetAttempts(nonceServer uint64) {
+	attemptsMutex.Lock()
+	defer attemptsMutex.Unlock()
+	for attempt := range solutionAttempts {
+		if attempt.nonceServer == nonceServer {
+			delete(solutionAttempts, attempt)
+		}
+	}
+}
+
+// LocalClientID is the client id of peers without an IP address, e.g. connected over a unix socket.
+// All of them share it, so they share replay protection too
+const LocalClientID = "local"
+
+// ClientID returns the id a client connected from addr puts in HashData.ClientID: its IP address, or LocalClientID
+func ClientID(addr net.Addr) string {
 	addrPort, err := netip.ParseAddrPort(addr.String())
 	if err != nil {
-		return addr.String()
+		return LocalClientID
 	}
 
-	return addrPort.Addr().String()
+	return addrPort.Addr().Unmap().String()
+}
+
+// ServerIDs are the names a server accepts in QuoteRequest.ServerID
+type ServerIDs []string
+
+func (ids ServerIDs) Contains(id string) bool {
+	for _, known := range ids {
+		if id == known {
+			return true
+		}
+	}
+	return false
 }
 
 func SolutionValid(challenge protocol.Challenge, serverAddr net.Addr, clientAddr net.Addr, req protocol.QuoteRequest) error {
 	if req.ServerID != serverAddr.String() {
-		return fmt.Errorf("server addr: %v != %v", req.ServerID, serverAddr.String())
+		return fmt.Errorf("%w: server addr: %v != %v", ErrServerID, req.ServerID, serverAddr.String())
 	}
-	if req.ClientID != stripPort(clientAddr) {
-		return fmt.Errorf("client addr: %v != %v", req.ClientID, stripPort(clientAddr))
+	return solutionValid(challenge, clientAddr, req)
+}
+
+// SolutionValidFor works like SolutionValid but checks the server id against a configured identity instead of the server address
+func SolutionValidFor(challenge protocol.Challenge, serverIDs ServerIDs, clientAddr net.Addr, req protocol.QuoteRequest) error {
+	if !serverIDs.Contains(req.ServerID) {
+		return fmt.Errorf("%w: %v is not one of %v", ErrServerID, req.ServerID, strings.Join(serverIDs, ", "))
+	}
+	return solutionValid(challenge, clientAddr, req)
+}
+
+func solutionValid(challenge protocol.Challenge, clientAddr net.Addr, req protocol.QuoteRequest) error {
+	if clientID := ClientID(clientAddr); req.ClientID != clientID {
+		return fmt.Errorf("%w: %v != %v", ErrClientID, req.ClientID, clientID)
 	}
 	if req.NonceServer != challenge.Nonce {
-		return fmt.Errorf("server nonce: %v != %v", req.NonceServer, challenge.Nonce)
+		return fmt.Errorf("%w: %v != %v", ErrNonce, req.NonceServer, challenge.Nonce)
 	}
 
 	attemptsMutex.Lock()
@@ -51,13 +109,13 @@ func SolutionValid(challenge protocol.Challenge, serverAddr net.Addr, clientAddr
 		nonceClient: req.NonceClient,
 	}
 	if _, ok := solutionAttempts[attempt]; ok {
-		return fmt.Errorf("attempt exist: %v", attempt)
+		return fmt.Errorf("%w: %v", ErrReplay, attempt)
 	}
 
 	calchash := Hash(&req.HashData)
 
 	if !HashMatchesChallenge(calchash, challenge) {
-		return fmt.Errorf("invalid hash solution %q: hash %v, complexity=%v", req.Bytes(), calchash, challenge.Complexity)
+		return fmt.Errorf("%w %q: hash %v, complexity=%v", ErrInvalidHash, req.Bytes(), calchash, challenge.Complexity)
 	}
 
 	solutionAttempts[attempt] = struct{}{}
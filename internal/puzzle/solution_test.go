@@ -243,3 +243,32 @@ func TestSolutionValid(t *testing.T) {
 		})
 	}
 }
+
+func TestSolutionValidFor(t *testing.T) {
+	challenge := protocol.Challenge{Nonce: 333, Complexity: 0}
+	clientAddr := &net.TCPAddr{IP: []byte{172, 18, 0, 3}, Port: 1234}
+	serverIDs := ServerIDs{"quotes.example.com", "quotes.internal"}
+
+	req := protocol.QuoteRequest{
+		ServerID: "quotes.internal",
+		HashData: protocol.HashData{
+			ClientID:    "172.18.0.3",
+			NonceServer: 333,
+			NonceClient: 444,
+			Solution:    []byte("xyz"),
+		},
+	}
+	assert.NoError(t, SolutionValidFor(challenge, serverIDs, clientAddr, req), "any alias is accepted")
+
+	req.ServerID = "172.18.0.2:9999"
+	req.NonceClient = 555
+	assert.EqualError(t, SolutionValidFor(challenge, serverIDs, clientAddr, req), "server id: 172.18.0.2:9999 is not one of quotes.example.com, quotes.internal")
+}
+
+func TestClientID(t *testing.T) {
+	assert.Equal(t, "172.18.0.3", ClientID(&net.TCPAddr{IP: net.IP{172, 18, 0, 3}, Port: 1234}))
+	assert.Equal(t, "172.18.0.3", ClientID(&net.TCPAddr{IP: net.ParseIP("::ffff:172.18.0.3"), Port: 1234}))
+	assert.Equal(t, "2001:db8::1", ClientID(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}))
+	assert.Equal(t, LocalClientID, ClientID(&net.UnixAddr{Name: "/run/powquote.sock", Net: "unix"}))
+	assert.Equal(t, LocalClientID, ClientID(&net.UnixAddr{Net: "unix"}))
+}
@@ -1,7 +1,9 @@
 package puzzle
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"math/rand"
 	"time"
 
@@ -10,19 +12,93 @@ import (
 
 var solutionSeed = time.Now().UnixNano()
 
+// progressCheckEvery is how many attempts are made between clock checks, so progress reporting stays cheap
+const progressCheckEvery = 1024
+
+type Progress struct {
+	Attempts uint64
+	Elapsed  time.Duration
+	// HashRate is the number of hashes per second calculated so far
+	HashRate float64
+	// Remaining is the expected time until a solution is found.
+	// Every attempt is independent, so it depends on the hash rate and complexity only, not on the attempts already made
+	Remaining time.Duration
+}
+
+// ExpectedAttempts returns the mean number of hashes needed to meet the complexity
+func ExpectedAttempts(complexity int) float64 {
+	if complexity <= 0 {
+		return 1
+	}
+	return math.Pow(16, float64(complexity))
+}
+
+// ExpectedDuration estimates the time needed to meet the complexity at the given hash rate
+func ExpectedDuration(complexity int, hashRate float64) time.Duration {
+	if hashRate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	seconds := ExpectedAttempts(complexity) / hashRate
+	if seconds >= float64(math.MaxInt64)/float64(time.Second) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
 func Solve(hashData *protocol.HashData, challenge protocol.Challenge) string {
+	hash, _ := SolveContext(context.Background(), hashData, challenge, 0, nil)
+	return hash
+}
+
+// SolveContext works like Solve, gives up when ctx is done and calls onProgress at most once per interval while solving
+func SolveContext(ctx context.Context, hashData *protocol.HashData, challenge protocol.Challenge, interval time.Duration, onProgress func(Progress)) (string, error) {
 	var lasthash string
 
-	rand.Seed(solutionSeed)
+	// a source of its own, so concurrent solvers don't reseed the global one
+	rnd := rand.New(rand.NewSource(solutionSeed))
 	hashData.Solution = make([]byte, 128)
 
+	started := time.Now()
+	lastReport := started
+	var attempts uint64
+
 	for {
-		_, _ = rand.Read(hashData.Solution)
+		_, _ = rnd.Read(hashData.Solution)
 		lasthash = Hash(hashData)
+		attempts++
 		if HashMatchesChallenge(lasthash, challenge) {
-			fmt.Printf("=== SEED=%v ===\n", solutionSeed)
 			break
 		}
+
+		if attempts%progressCheckEvery != 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if onProgress == nil {
+			continue
+		}
+		if now := time.Now(); now.Sub(lastReport) >= interval {
+			lastReport = now
+			onProgress(newProgress(attempts, now.Sub(started), challenge.Complexity))
+		}
 	}
-	return lasthash
+	return lasthash, nil
+}
+
+func newProgress(attempts uint64, elapsed time.Duration, complexity int) Progress {
+	p := Progress{
+		Attempts: attempts,
+		Elapsed:  elapsed,
+	}
+	if elapsed > 0 {
+		p.HashRate = float64(attempts) / elapsed.Seconds()
+	}
+	p.Remaining = ExpectedDuration(complexity, p.HashRate)
+	return p
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("%d attempts in %v, %.0f hashes/s, expected %v remaining", p.Attempts, p.Elapsed.Round(time.Millisecond), p.HashRate, p.Remaining.Round(time.Second))
 }
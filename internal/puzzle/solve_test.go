@@ -1,8 +1,11 @@
 package puzzle
 
 import (
+	"context"
+	"math"
 	"strconv"
 	"testing"
+	"time"
 
 	"powquote/internal/protocol"
 
@@ -33,8 +36,72 @@ func TestSolve(t *testing.T) {
 	}
 	for name, tt := range tests {
 		t.Run(strconv.Itoa(name), func(t *testing.T) {
-			solutionSeed = tt.seed
+			setSolutionSeed(t, tt.seed)
 			assert.Equalf(t, tt.want, Solve(&tt.hashData, tt.challenge), "Solve(%v, %v)", tt.hashData, tt.challenge)
 		})
 	}
 }
+
+func TestSolveContext(t *testing.T) {
+	hashData := protocol.HashData{
+		ClientID:    "172.18.0.3",
+		NonceServer: 111,
+		NonceClient: 222,
+	}
+	challenge := protocol.Challenge{
+		Nonce:      111,
+		Complexity: 4,
+	}
+
+	setSolutionSeed(t, 1)
+	var reports []Progress
+	hash, err := SolveContext(context.Background(), &hashData, challenge, 0, func(p Progress) {
+		reports = append(reports, p)
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, HashMatchesChallenge(hash, challenge))
+	if assert.NotEmpty(t, reports) {
+		last := reports[len(reports)-1]
+		assert.Greater(t, last.Attempts, uint64(0))
+		assert.Greater(t, last.HashRate, float64(0))
+		assert.Greater(t, last.Remaining, time.Duration(0))
+	}
+}
+
+func TestSolveContext_Cancelled(t *testing.T) {
+	hashData := protocol.HashData{ClientID: "172.18.0.3"}
+	challenge := protocol.Challenge{Complexity: 40}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	_, err := SolveContext(ctx, &hashData, challenge, 0, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestExpectedDuration(t *testing.T) {
+	tests := []struct {
+		complexity int
+		hashRate   float64
+		want       time.Duration
+	}{
+		{complexity: 0, hashRate: 1, want: time.Second},
+		{complexity: 2, hashRate: 256, want: time.Second},
+		{complexity: 3, hashRate: 1024, want: 4 * time.Second},
+		{complexity: 3, hashRate: 0, want: time.Duration(math.MaxInt64)},
+		{complexity: 40, hashRate: 1, want: time.Duration(math.MaxInt64)},
+	}
+	for name, tt := range tests {
+		t.Run(strconv.Itoa(name), func(t *testing.T) {
+			assert.Equal(t, tt.want, ExpectedDuration(tt.complexity, tt.hashRate))
+		})
+	}
+}
+
+// setSolutionSeed makes the solutions of the test repeatable and restores the seed afterwards
+func setSolutionSeed(t *testing.T, seed int64) {
+	old := solutionSeed
+	t.Cleanup(func() { solutionSeed = old })
+	solutionSeed = seed
+}
@@ -0,0 +1,53 @@
+package quotes
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// List is a set of quotes loaded from a file
+type List []string
+
+// Next returns a random quote from the list
+func (l List) Next() string {
+	return l[rand.Intn(len(l))]
+}
+
+// Parse reads quotes, one per line. Empty lines and lines starting with # are ignored
+func Parse(r io.Reader) (List, error) {
+	var l List
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		l = append(l, text)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(l) == 0 {
+		return nil, errors.New("no quotes")
+	}
+	return l, nil
+}
+
+func Load(path string) (List, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return l, nil
+}
@@ -0,0 +1,19 @@
+package quotes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	l, err := Parse(strings.NewReader("# comment\nfirst\n\n  second  \n"))
+	require.NoError(t, err)
+	assert.Equal(t, List{"first", "second"}, l)
+	assert.Contains(t, l, l.Next())
+
+	_, err = Parse(strings.NewReader("# nothing\n"))
+	assert.Error(t, err)
+}
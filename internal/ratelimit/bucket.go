@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Rate is a token bucket refill rate and capacity; zero PerSecond means no limit
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a set of token buckets keyed by K
+type Limiter[K comparable] struct {
+	rate Rate
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets map[K]*bucket
+}
+
+func NewLimiter[K comparable](rate Rate) *Limiter[K] {
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	return &Limiter[K]{
+		rate:    rate,
+		now:     time.Now,
+		buckets: make(map[K]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of key and reports whether there was one
+func (l *Limiter[K]) Allow(key K) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return take(l.bucketOf(key))
+}
+
+// bucketOf returns the refilled bucket of key, nil when there is no limit; l.mu must be held
+func (l *Limiter[K]) bucketOf(key K) *bucket {
+	if l.rate.PerSecond <= 0 {
+		return nil
+	}
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Burst), last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+	return b
+}
+
+func (b *bucket) hasToken() bool {
+	return b == nil || b.tokens >= 1
+}
+
+// take takes a token from b if it has one, a nil bucket always has one
+func take(b *bucket) bool {
+	if !b.hasToken() {
+		return false
+	}
+	if b != nil {
+		b.tokens--
+	}
+	return true
+}
+
+func (l *Limiter[K]) refill(b *bucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * l.rate.PerSecond
+	if burst := float64(l.rate.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// Prune forgets buckets that have refilled completely, they are no different from new ones
+func (l *Limiter[K]) Prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= float64(l.rate.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of tracked buckets
+func (l *Limiter[K]) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := NewLimiter[string](Rate{PerSecond: 2, Burst: 3})
+	l.now = clock.now
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow("a"), "burst %v", i)
+	}
+	assert.False(t, l.Allow("a"), "burst exhausted")
+	assert.True(t, l.Allow("b"), "keys are independent")
+
+	clock.t = clock.t.Add(time.Millisecond * 500)
+	assert.True(t, l.Allow("a"), "one token refilled")
+	assert.False(t, l.Allow("a"))
+
+	clock.t = clock.t.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow("a"), "refill is capped by burst %v", i)
+	}
+	assert.False(t, l.Allow("a"))
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := NewLimiter[string](Rate{})
+	for i := 0; i < 100; i++ {
+		assert.True(t, l.Allow("a"))
+	}
+	assert.Equal(t, 0, l.Len())
+}
+
+func TestLimiter_Prune(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := NewLimiter[string](Rate{PerSecond: 1, Burst: 2})
+	l.now = clock.now
+
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("b")
+
+	clock.t = clock.t.Add(time.Second)
+	l.Prune()
+	assert.Equal(t, 1, l.Len(), "a is full again, b is not")
+
+	clock.t = clock.t.Add(time.Second)
+	l.Prune()
+	assert.Equal(t, 0, l.Len())
+}
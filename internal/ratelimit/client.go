@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"net/netip"
+)
+
+type Config struct {
+	PerIP     Rate
+	PerSubnet Rate
+	// IPv4PrefixLen and IPv6PrefixLen define the subnet a client address belongs to; zero or out of range values mean 24 and 64
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+// ClientLimiter limits clients both by their own IP and by the subnet they come from
+type ClientLimiter struct {
+	cfg    Config
+	ip     *Limiter[netip.Addr]
+	subnet *Limiter[netip.Prefix]
+}
+
+func NewClientLimiter(cfg Config) *ClientLimiter {
+	if cfg.IPv4PrefixLen <= 0 || cfg.IPv4PrefixLen > 32 {
+		cfg.IPv4PrefixLen = 24
+	}
+	if cfg.IPv6PrefixLen <= 0 || cfg.IPv6PrefixLen > 128 {
+		cfg.IPv6PrefixLen = 64
+	}
+	return &ClientLimiter{
+		cfg:    cfg,
+		ip:     NewLimiter[netip.Addr](cfg.PerIP),
+		subnet: NewLimiter[netip.Prefix](cfg.PerSubnet),
+	}
+}
+
+// Allow takes a token from both the IP and the subnet bucket of addr if both have one, so a denied client keeps its tokens
+func (c *ClientLimiter) Allow(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	c.ip.mu.Lock()
+	defer c.ip.mu.Unlock()
+	c.subnet.mu.Lock()
+	defer c.subnet.mu.Unlock()
+
+	ip, subnet := c.ip.bucketOf(addr), c.subnet.bucketOf(c.subnetOf(addr))
+	if !ip.hasToken() || !subnet.hasToken() {
+		return false
+	}
+	take(ip)
+	take(subnet)
+	return true
+}
+
+func (c *ClientLimiter) subnetOf(addr netip.Addr) netip.Prefix {
+	bits := c.cfg.IPv6PrefixLen
+	if addr.Is4() {
+		bits = c.cfg.IPv4PrefixLen
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return netip.PrefixFrom(addr, addr.BitLen())
+	}
+	return prefix
+}
+
+func (c *ClientLimiter) Prune() {
+	c.ip.Prune()
+	c.subnet.Prune()
+}
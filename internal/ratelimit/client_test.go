@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientLimiter_Allow(t *testing.T) {
+	l := NewClientLimiter(Config{
+		PerIP:         Rate{PerSecond: 0.001, Burst: 1},
+		PerSubnet:     Rate{PerSecond: 0.001, Burst: 2},
+		IPv4PrefixLen: 24,
+		IPv6PrefixLen: 48,
+	})
+
+	assert.True(t, l.Allow(netip.MustParseAddr("10.0.0.1")))
+	assert.False(t, l.Allow(netip.MustParseAddr("10.0.0.1")), "per IP limit")
+	assert.False(t, l.Allow(netip.MustParseAddr("::ffff:10.0.0.1")), "IPv4-mapped address is the same client")
+	assert.True(t, l.Allow(netip.MustParseAddr("10.0.0.2")))
+	assert.False(t, l.Allow(netip.MustParseAddr("10.0.0.3")), "per subnet limit")
+	assert.True(t, l.Allow(netip.MustParseAddr("10.0.1.1")), "another subnet")
+
+	assert.True(t, l.Allow(netip.MustParseAddr("2001:db8:1:1::1")))
+	assert.True(t, l.Allow(netip.MustParseAddr("2001:db8:1:2::1")))
+	assert.False(t, l.Allow(netip.MustParseAddr("2001:db8:1:3::1")), "per /48 limit")
+	assert.True(t, l.Allow(netip.MustParseAddr("2001:db8:2::1")))
+}
+
+func TestClientLimiter_AllowKeepsTokensWhenDenied(t *testing.T) {
+	l := NewClientLimiter(Config{
+		PerIP:         Rate{PerSecond: 0.001, Burst: 1},
+		PerSubnet:     Rate{PerSecond: 0.001, Burst: 1},
+		IPv4PrefixLen: 24,
+	})
+
+	assert.True(t, l.Allow(netip.MustParseAddr("10.0.0.1")))
+	assert.False(t, l.Allow(netip.MustParseAddr("10.0.0.2")), "per subnet limit")
+
+	l.subnet = NewLimiter[netip.Prefix](Rate{})
+	assert.True(t, l.Allow(netip.MustParseAddr("10.0.0.2")), "the denied IP kept its token")
+}
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+)
+
+// remoteIP returns the IP address of the client, if the connection has one
+func remoteIP(conn net.Conn) (netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), true
+}
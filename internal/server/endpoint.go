@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// endpoint is one listener of the server: it shares the puzzle state of the server, nonces, replay protection, limits and bans,
+// and has its own transport settings and counters
+type endpoint struct {
+	*Server
+	name      string
+	tlsConfig *tls.Config
+	stats     *counters
+}
+
+// newEndpoint returns an endpoint counting into the stats of name; endpoints with the same name share them
+func (s *Server) newEndpoint(name string, tlsConfig *tls.Config) *endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.endpoints[name]
+	if !ok {
+		c = &counters{}
+		s.endpoints[name] = c
+	}
+	return &endpoint{Server: s, name: name, tlsConfig: tlsConfig, stats: c}
+}
+
+// endpointName is the default listener name, scheme://address
+func endpointName(scheme string, addr net.Addr) string {
+	return scheme + "://" + addr.String()
+}
+
+// Stats returns the current values of the server counters, summed over all listeners
+func (s *Server) Stats() Stats {
+	var total Stats
+	for _, stats := range s.ListenerStats() {
+		total = total.add(stats)
+	}
+	return total
+}
+
+// ListenerStats returns the current values of the counters of each listener by name
+func (s *Server) ListenerStats() map[string]Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]Stats, len(s.endpoints))
+	for name, c := range s.endpoints {
+		stats[name] = c.snapshot()
+	}
+	return stats
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"powquote/internal/listen"
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+	"powquote/internal/websocket"
+)
+
+// GatewayChallenge is the JSON form of protocol.Challenge returned by GET /challenge
+type GatewayChallenge struct {
+	Nonce      uint64 `json:"nonce"`
+	Complexity int    `json:"complexity"`
+	ClientAddr string `json:"client_addr"`
+	ServerID   string `json:"server_id"`
+	Signature  []byte `json:"signature"`
+	// Challenge is the wire form of the challenge, see protocol.ChallengeFromBytes
+	Challenge string `json:"challenge"`
+}
+
+// GatewayQuoteRequest is the JSON form of protocol.QuoteRequest accepted by POST /quote
+type GatewayQuoteRequest struct {
+	ServerID    string `json:"server_id"`
+	ClientID    string `json:"client_id"`
+	NonceServer uint64 `json:"nonce_server"`
+	NonceClient uint64 `json:"nonce_client"`
+	Solution    []byte `json:"solution"`
+}
+
+// GatewayQuote is the response to an accepted POST /quote
+type GatewayQuote struct {
+	Quote string `json:"quote"`
+}
+
+// GatewayError is the response to any failed gateway request
+type GatewayError struct {
+	Error string `json:"error"`
+}
+
+// Gateway returns an HTTP front end for the puzzle: GET /challenge issues a challenge and POST /quote exchanges a solution for the resource.
+// GET /ws runs the TCP protocol over a websocket, one message per line: HELLO, the challenge, the quote request and the resource.
+// It shares nonces, replay protection and limits with the other listeners and counts as the "gateway" listener; the client identity is taken from the HTTP connection
+func (s *Server) Gateway() http.Handler {
+	return s.newEndpoint("gateway", nil).gateway()
+}
+
+func (e *endpoint) gateway() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/challenge", e.gatewayChallenge)
+	mux.HandleFunc("/quote", e.gatewayQuote)
+	mux.HandleFunc("/ws", e.gatewayWebSocket)
+	return mux
+}
+
+// ListenAndServeGateway listens on addr, host:port or unix:/path, and serves the gateway until ctx is done, see ServeGateway
+func (s *Server) ListenAndServeGateway(ctx context.Context, addr string) error {
+	ln, err := listen.Listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeGateway(ctx, ln)
+}
+
+// ServeGateway serves the gateway on ln until ctx is done, then gives in-flight requests and websocket sessions the drain timeout to finish.
+// PROXY protocol and TLS are applied to ln the same way as in Serve
+func (s *Server) ServeGateway(ctx context.Context, ln net.Listener) error {
+	if s.tlsConfig != nil {
+		return s.newEndpoint(endpointName("https", ln.Addr()), s.tlsConfig).serveGateway(ctx, ln)
+	}
+	return s.newEndpoint(endpointName("http", ln.Addr()), nil).serveGateway(ctx, ln)
+}
+
+func (e *endpoint) serveGateway(ctx context.Context, ln net.Listener) error {
+	defer e.start()()
+	// Shutdown does not wait for websocket sessions, which the server tracks like connections
+	defer func() {
+		if err := e.drain(); err != nil {
+			e.logger.Print(err)
+		}
+	}()
+
+	srv := &http.Server{
+		Handler:           e.gateway(),
+		ReadHeaderTimeout: e.requestTimeout,
+		ReadTimeout:       e.requestTimeout,
+		WriteTimeout:      e.ioTimeout,
+		IdleTimeout:       e.ioTimeout,
+		ErrorLog:          e.logger,
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		e.logger.Printf("stopping gateway on %v", ln.Addr())
+		drainCtx, cancel := context.WithTimeout(context.Background(), e.drainTimeout)
+		defer cancel()
+		stopped <- srv.Shutdown(drainCtx)
+	}()
+
+	e.logger.Printf("begin serving gateway on %v; DoS protected = %v, complexity = %v", ln.Addr(), e.settings().protected, e.settings().complexity)
+	err := srv.Serve(e.wrap(ln))
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-stopped
+}
+
+func (e *endpoint) gatewayChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeGatewayError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	a, conn, ok := e.admitRequest(w, r)
+	if !ok {
+		return
+	}
+	defer e.release()
+	if a.exempt {
+		a.complexity = 0
+	}
+
+	challenge := protocol.Challenge{
+		Nonce:      e.nonces.Current(),
+		Complexity: a.complexity,
+	}
+	e.logf(LogInfo, "(%v) gateway challenge request", r.RemoteAddr)
+	e.issue(&challenge, conn.remote, conn.local)
+	writeGatewayJSON(w, http.StatusOK, GatewayChallenge{
+		Nonce:      challenge.Nonce,
+		Complexity: challenge.Complexity,
+		ClientAddr: challenge.ClientAddr,
+		ServerID:   challenge.ServerID,
+		Signature:  challenge.Signature,
+		Challenge:  string(challenge.Bytes()),
+	})
+}
+
+func (e *endpoint) gatewayQuote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeGatewayError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	a, conn, ok := e.admitRequest(w, r)
+	if !ok {
+		return
+	}
+	defer e.release()
+
+	if !a.exempt {
+		bs, err := io.ReadAll(io.LimitReader(r.Body, int64(e.maxRequestBytes)+1))
+		if err != nil {
+			e.logf(LogWarn, "(%v) error reading request: %v", r.RemoteAddr, err)
+			writeGatewayError(w, http.StatusBadRequest, "error reading request")
+			return
+		}
+		if len(bs) > e.maxRequestBytes {
+			e.logf(LogWarn, "(%v) no complete request in %v bytes", r.RemoteAddr, e.maxRequestBytes)
+			atomic.AddUint64(&e.stats.RequestsTooLarge, 1)
+			writeGatewayError(w, http.StatusRequestEntityTooLarge, "request is too large")
+			return
+		}
+		var body GatewayQuoteRequest
+		if err := json.Unmarshal(bs, &body); err != nil {
+			e.strike(conn)
+			writeGatewayError(w, http.StatusBadRequest, "invalid quote request: "+err.Error())
+			return
+		}
+
+		req := protocol.QuoteRequest{
+			ServerID: body.ServerID,
+			HashData: protocol.HashData{
+				ClientID:    body.ClientID,
+				NonceServer: body.NonceServer,
+				NonceClient: body.NonceClient,
+				Solution:    body.Solution,
+			},
+		}
+
+		e.logf(LogInfo, "(%v) gateway quote request", r.RemoteAddr)
+		if err := e.verify(conn, a, req); err != nil {
+			writeGatewayError(w, http.StatusForbidden, string(protocol.InvalidSolution))
+			return
+		}
+	}
+
+	if err := e.serveHandler(conn); err != nil {
+		e.logf(LogError, "(%v) error serving resource: %v", r.RemoteAddr, err)
+		writeGatewayError(w, http.StatusInternalServerError, "error serving resource")
+		return
+	}
+	atomic.AddUint64(&e.stats.Served, 1)
+	writeGatewayJSON(w, http.StatusOK, GatewayQuote{Quote: conn.buf.String()})
+}
+
+func (e *endpoint) gatewayWebSocket(w http.ResponseWriter, r *http.Request) {
+	a, conn, ok := e.admitRequest(w, r)
+	if !ok {
+		return
+	}
+	defer e.release()
+	ws, err := websocket.Upgrade(w, r)
+	if err != nil {
+		e.logf(LogWarn, "(%v) websocket handshake error: %v", r.RemoteAddr, err)
+		atomic.AddUint64(&e.stats.HandshakeErrors, 1)
+		return
+	}
+	defer ws.Close()
+	e.track(ws)
+	defer e.untrack(ws)
+	ws.MaxMessageSize = e.maxRequestBytes
+
+	deadline := time.Now().Add(e.ioTimeout)
+	if err := ws.SetDeadline(deadline); err != nil {
+		e.logf(LogError, "(%v) error setting deadline: %v", r.RemoteAddr, err)
+	}
+
+	// handlers and responses write to conn, its content is sent as a message
+	send := func() {
+		if err := ws.WriteMessage(websocket.TextMessage, conn.buf.Bytes()); err != nil {
+			e.logf(LogError, "(%v) error writing message: %v", r.RemoteAddr, err)
+		}
+		conn.buf.Reset()
+	}
+
+	req, ok := e.nextMessage(ws, conn, e.requestTimeout, deadline)
+	if !ok {
+		return
+	}
+	if a.exempt {
+		e.serveResource(conn)
+		send()
+		return
+	}
+
+	switch req := req.(type) {
+	case protocol.ChallengeRequest:
+		challenge := protocol.Challenge{
+			Nonce:      e.nonces.Current(),
+			Complexity: a.complexity,
+		}
+		e.logf(LogInfo, "(%v) websocket challenge request", r.RemoteAddr)
+		e.issue(&challenge, conn.remote, conn.local)
+		e.writeResponse(conn, challenge.Bytes())
+		send()
+
+		// the client has the rest of the I/O timeout to solve the challenge
+		next, ok := e.nextMessage(ws, conn, e.ioTimeout, deadline)
+		if !ok {
+			return
+		}
+		quoteReq, ok := next.(protocol.QuoteRequest)
+		if !ok {
+			e.writeResponse(conn, []byte("send the solution to the challenge"))
+			send()
+			return
+		}
+		e.redeem(conn, a, quoteReq)
+	case protocol.QuoteRequest:
+		e.redeem(conn, a, req)
+	}
+	send()
+}
+
+// nextMessage reads a request from a websocket message within timeout, but not after deadline; malformed requests strike the client of conn
+func (e *endpoint) nextMessage(ws *websocket.Conn, conn net.Conn, timeout time.Duration, deadline time.Time) (any, bool) {
+	if requestDeadline := time.Now().Add(timeout); requestDeadline.Before(deadline) {
+		deadline = requestDeadline
+	}
+	if err := ws.SetReadDeadline(deadline); err != nil {
+		return nil, false
+	}
+
+	_, msg, err := ws.ReadMessage()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		e.logf(LogWarn, "(%v) request was not received in %v", ws.RemoteAddr(), timeout)
+		atomic.AddUint64(&e.stats.RequestTimeouts, 1)
+		return nil, false
+	}
+	if errors.Is(err, websocket.ErrMessageTooLarge) {
+		e.logf(LogWarn, "(%v) no complete request in %v bytes", ws.RemoteAddr(), e.maxRequestBytes)
+		atomic.AddUint64(&e.stats.RequestsTooLarge, 1)
+		return nil, false
+	}
+	if err != nil {
+		e.logf(LogWarn, "(%v) error reading message: %v", ws.RemoteAddr(), err)
+		return nil, false
+	}
+
+	req, err := puzzle.ReadRequestMax(bytes.NewReader(msg), e.maxRequestBytes)
+	if err != nil {
+		e.logf(LogWarn, "(%v) error processing request: %v", ws.RemoteAddr(), err)
+		if errors.Is(err, puzzle.ErrMalformedRequest) {
+			e.strike(conn)
+		}
+		_ = ws.WriteMessage(websocket.TextMessage, []byte("send hello request to begin client puzzle"))
+		return nil, false
+	}
+	return req, true
+}
+
+// admitRequest applies the same admission rules and connection limit as to TCP connections and writes an error response if the request is refused.
+// Admitted requests hold a connection slot until the caller releases it, websocket sessions included.
+// The returned connection has the addresses of the request for handlers to write the resource to
+func (e *endpoint) admitRequest(w http.ResponseWriter, r *http.Request) (admission, *bufferConn, bool) {
+	atomic.AddUint64(&e.stats.Connections, 1)
+
+	conn := &bufferConn{local: localAddr(r)}
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	hasIP := err == nil
+	if hasIP {
+		remote = netip.AddrPortFrom(remote.Addr().Unmap(), remote.Port())
+		conn.remote = net.TCPAddrFromAddrPort(remote)
+	} else {
+		// e.g. a unix socket
+		conn.remote = &net.UnixAddr{Name: r.RemoteAddr, Net: "unix"}
+	}
+
+	a, refused := e.admitClient(r.RemoteAddr, remote.Addr(), hasIP, r.TLS, true)
+	switch refused {
+	case refusedDenied, refusedBanned:
+		writeGatewayError(w, http.StatusForbidden, "access denied")
+		return a, conn, false
+	case refusedRateLimited:
+		writeGatewayError(w, http.StatusTooManyRequests, string(protocol.RateLimited))
+		return a, conn, false
+	}
+	if !e.acquire() {
+		atomic.AddUint64(&e.stats.Overloaded, 1)
+		e.logf(LogWarn, "(%v) too many connections, rejecting", r.RemoteAddr)
+		writeGatewayError(w, http.StatusServiceUnavailable, string(protocol.Overloaded))
+		return a, conn, false
+	}
+	return a, conn, true
+}
+
+func localAddr(r *http.Request) net.Addr {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
+
+func writeGatewayJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeGatewayError(w http.ResponseWriter, status int, msg string) {
+	writeGatewayJSON(w, status, GatewayError{Error: msg})
+}
+
+// bufferConn collects what a Handler writes so it can be sent in an HTTP response or a datagram
+type bufferConn struct {
+	buf    bytes.Buffer
+	local  net.Addr
+	remote net.Addr
+	// unverified is set when remote may be spoofed, like the source of a datagram
+	unverified bool
+}
+
+func (c *bufferConn) Read([]byte) (int, error)         { return 0, io.EOF }
+func (c *bufferConn) Write(p []byte) (int, error)      { return c.buf.Write(p) }
+func (c *bufferConn) Close() error                     { return nil }
+func (c *bufferConn) LocalAddr() net.Addr              { return c.local }
+func (c *bufferConn) RemoteAddr() net.Addr             { return c.remote }
+func (c *bufferConn) SetDeadline(time.Time) error      { return nil }
+func (c *bufferConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *bufferConn) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+	"powquote/internal/ratelimit"
+	"powquote/internal/websocket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startGateway(t *testing.T, opts ...Option) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	startServer(t, func(ctx context.Context, srv *Server) error {
+		return srv.ServeGateway(ctx, ln)
+	}, opts...)
+	return "http://" + ln.Addr().String()
+}
+
+func gatewayCall(t *testing.T, method, url string, body any, out any) int {
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	return resp.StatusCode
+}
+
+func TestServer_Gateway(t *testing.T) {
+	url := startGateway(t, WithComplexity(2), WithHandler(fixedHandler))
+
+	var ch GatewayChallenge
+	require.Equal(t, http.StatusOK, gatewayCall(t, http.MethodGet, url+"/challenge", nil, &ch))
+	assert.Equal(t, 2, ch.Complexity)
+	assert.Equal(t, "127.0.0.1", ch.ClientAddr)
+
+	challenge, err := protocol.ChallengeFromBytes([]byte(ch.Challenge))
+	require.NoError(t, err)
+	assert.Equal(t, ch.Nonce, challenge.Nonce)
+	assert.Equal(t, ch.Signature, challenge.Signature)
+
+	hashData := protocol.HashData{ClientID: ch.ClientAddr, NonceServer: ch.Nonce, NonceClient: puzzle.GenerateNonceOnce()}
+	puzzle.Solve(&hashData, challenge)
+	req := GatewayQuoteRequest{
+		ServerID:    ch.ServerID,
+		ClientID:    hashData.ClientID,
+		NonceServer: hashData.NonceServer,
+		NonceClient: hashData.NonceClient,
+		Solution:    hashData.Solution,
+	}
+
+	var q GatewayQuote
+	require.Equal(t, http.StatusOK, gatewayCall(t, http.MethodPost, url+"/quote", req, &q))
+	assert.Equal(t, "resource", q.Quote)
+
+	var e GatewayError
+	assert.Equal(t, http.StatusForbidden, gatewayCall(t, http.MethodPost, url+"/quote", req, &e), "replay")
+	assert.Equal(t, string(protocol.InvalidSolution), e.Error)
+
+	assert.Equal(t, http.StatusBadRequest, gatewayCall(t, http.MethodPost, url+"/quote", "garbage", &e))
+	assert.Equal(t, http.StatusMethodNotAllowed, gatewayCall(t, http.MethodGet, url+"/quote", nil, &e))
+}
+
+func TestServer_GatewayRateLimit(t *testing.T) {
+	limiter := ratelimit.NewClientLimiter(ratelimit.Config{PerIP: ratelimit.Rate{PerSecond: 0.001, Burst: 1}})
+	url := startGateway(t, WithRateLimiter(limiter))
+
+	var ch GatewayChallenge
+	assert.Equal(t, http.StatusOK, gatewayCall(t, http.MethodGet, url+"/challenge", nil, &ch))
+	var e GatewayError
+	assert.Equal(t, http.StatusTooManyRequests, gatewayCall(t, http.MethodGet, url+"/challenge", nil, &e))
+	assert.Equal(t, string(protocol.RateLimited), e.Error)
+}
+
+func TestServer_GatewayUnprotected(t *testing.T) {
+	url := startGateway(t, WithProtection(false), WithHandler(fixedHandler))
+
+	var q GatewayQuote
+	require.Equal(t, http.StatusOK, gatewayCall(t, http.MethodPost, url+"/quote", nil, &q))
+	assert.Equal(t, "resource", q.Quote)
+}
+
+func TestServer_GatewayWebSocket(t *testing.T) {
+	url := startGateway(t, WithComplexity(2), WithHandler(fixedHandler))
+	addr := strings.TrimPrefix(url, "http://")
+
+	dial := func() *websocket.Conn {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		ws, err := websocket.Dial(conn, "ws://"+addr+"/ws")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = ws.Close()
+		})
+		return ws
+	}
+	say := func(ws *websocket.Conn, msg []byte) string {
+		require.NoError(t, ws.WriteMessage(websocket.TextMessage, msg))
+		_, reply, err := ws.ReadMessage()
+		require.NoError(t, err)
+		return string(reply)
+	}
+
+	ws := dial()
+	challenge, err := protocol.ChallengeFromBytes([]byte(say(ws, protocol.Hello)))
+	require.NoError(t, err)
+	assert.Equal(t, 2, challenge.Complexity)
+
+	req := protocol.QuoteRequest{
+		ServerID: challenge.ServerID,
+		HashData: protocol.HashData{ClientID: challenge.ClientAddr, NonceServer: challenge.Nonce, NonceClient: puzzle.GenerateNonceOnce()},
+	}
+	puzzle.Solve(&req.HashData, challenge)
+	assert.Equal(t, "resource", say(ws, req.Bytes()))
+
+	assert.Equal(t, string(protocol.InvalidSolution), say(dial(), req.Bytes()), "replay")
+	assert.Equal(t, "send hello request to begin client puzzle", say(dial(), []byte("garbage")))
+}
+
+func TestServer_GatewayMaxConnections(t *testing.T) {
+	url := startGateway(t, WithComplexity(2), WithMaxConnections(1))
+	addr := strings.TrimPrefix(url, "http://")
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	ws, err := websocket.Dial(conn, "ws://"+addr+"/ws")
+	require.NoError(t, err)
+	defer ws.Close()
+	// the session holds its slot while waiting for the solution
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, protocol.Hello))
+	_, _, err = ws.ReadMessage()
+	require.NoError(t, err)
+
+	var e GatewayError
+	assert.Equal(t, http.StatusServiceUnavailable, gatewayCall(t, http.MethodGet, url+"/challenge", nil, &e))
+	assert.Equal(t, string(protocol.Overloaded), e.Error)
+}
+
+func TestServer_GatewayDrainsWebSockets(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := New("", WithLogger(discardLogger), WithComplexity(2), WithDrainTimeout(time.Millisecond*200))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.ServeGateway(ctx, ln)
+	}()
+
+	addr := ln.Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	ws, err := websocket.Dial(conn, "ws://"+addr+"/ws")
+	require.NoError(t, err)
+	defer ws.Close()
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, protocol.Hello))
+	_, _, err = ws.ReadMessage()
+	require.NoError(t, err)
+
+	cancel()
+	select {
+	case <-served:
+		t.Fatal("gateway stopped with a websocket session running")
+	case <-time.After(time.Millisecond * 100):
+	}
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("websocket session is not closed after the drain timeout")
+	}
+	_, _, err = ws.ReadMessage()
+	assert.Error(t, err)
+}
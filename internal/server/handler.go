@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net"
+
+	"powquote/internal/quotes"
+)
+
+// Handler serves the protected resource to a client that has passed the puzzle (or to anyone if protection is off)
+type Handler interface {
+	ServeConn(conn net.Conn) error
+}
+
+type HandlerFunc func(conn net.Conn) error
+
+func (f HandlerFunc) ServeConn(conn net.Conn) error {
+	return f(conn)
+}
+
+// QuoteHandler writes a random quote
+var QuoteHandler = HandlerFunc(func(conn net.Conn) error {
+	_, err := conn.Write([]byte(quotes.Next()))
+	return err
+})
+
+// ListHandler writes a random quote from the list
+func ListHandler(list quotes.List) Handler {
+	return HandlerFunc(func(conn net.Conn) error {
+		_, err := conn.Write([]byte(list.Next()))
+		return err
+	})
+}
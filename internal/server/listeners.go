@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"powquote/internal/listen"
+)
+
+// Listener is one transport served by Run
+type Listener struct {
+	// Name identifies the listener in ListenerStats, scheme://address by default
+	Name string
+	// Scheme is tcp, tls, http, https, udp, or metrics for the Prometheus metrics on /metrics
+	Scheme string
+	// Addr is host:port, or unix:/path for stream schemes; it is ignored when Listener or PacketConn is set
+	Addr string
+	// TLS is the config of tls and https listeners, the one set with WithTLS by default
+	TLS *tls.Config
+	// Listener and PacketConn are already open sockets, e.g. passed by systemd
+	Listener   net.Listener
+	PacketConn net.PacketConn
+}
+
+// ParseListener parses a listener spec, scheme://address, e.g. tcp://:8080, https://:8443 or http://unix:/run/pow.sock
+func ParseListener(spec string) (Listener, error) {
+	scheme, addr, ok := strings.Cut(spec, "://")
+	if !ok || addr == "" {
+		return Listener{}, fmt.Errorf("invalid listener %q; should be scheme://address", spec)
+	}
+	switch scheme {
+	case "tcp", "tls", "http", "https", "metrics":
+	case "udp":
+		if strings.HasPrefix(addr, "unix:") {
+			return Listener{}, fmt.Errorf("invalid listener %q; udp needs host:port", spec)
+		}
+	default:
+		return Listener{}, fmt.Errorf("invalid listener %q; scheme should be tcp, tls, http, https, udp or metrics", spec)
+	}
+	return Listener{Name: spec, Scheme: scheme, Addr: addr}, nil
+}
+
+// ParseListeners parses a comma separated list of listener specs, see ParseListener
+func ParseListeners(specs string) ([]Listener, error) {
+	var listeners []Listener
+	for _, spec := range strings.Split(specs, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		l, err := ParseListener(spec)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Run serves all listeners until ctx is done, sharing nonces, replay protection, complexity, limits and bans.
+// The sockets are opened before anything is served, and the first listener to fail stops the others.
+// Each listener has its own counters, see ListenerStats
+func (s *Server) Run(ctx context.Context, listeners []Listener) error {
+	endpoints := make([]*endpoint, len(listeners))
+	names := make(map[string]bool, len(listeners))
+	for i, l := range listeners {
+		e, err := s.listenerSpec(l)
+		if err != nil {
+			return err
+		}
+		if names[e.name] {
+			return fmt.Errorf("duplicate listener %q", e.name)
+		}
+		names[e.name] = true
+		endpoints[i] = e
+	}
+
+	opened := make([]Listener, len(listeners))
+	closeOpened := func() {
+		for _, l := range opened {
+			if l.Listener != nil {
+				_ = l.Listener.Close()
+			}
+			if l.PacketConn != nil {
+				_ = l.PacketConn.Close()
+			}
+		}
+	}
+	for i, l := range listeners {
+		if l.Listener == nil && l.PacketConn == nil {
+			var err error
+			if l.Scheme == "udp" {
+				l.PacketConn, err = net.ListenPacket("udp", l.Addr)
+			} else {
+				l.Listener, err = listen.Listen(l.Addr)
+			}
+			if err != nil {
+				closeOpened()
+				return fmt.Errorf("error opening listener %v: %w", endpoints[i].name, err)
+			}
+		}
+		opened[i] = l
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error)
+	for i, l := range opened {
+		e, l := endpoints[i], l
+		go func() {
+			var err error
+			switch {
+			case l.PacketConn != nil:
+				err = e.serveUDP(ctx, l.PacketConn)
+			case l.Scheme == "http" || l.Scheme == "https":
+				err = e.serveGateway(ctx, l.Listener)
+			case l.Scheme == "metrics":
+				err = e.serveMetrics(ctx, l.Listener)
+			default:
+				err = e.serveContext(ctx, l.Listener)
+			}
+			if err != nil {
+				err = fmt.Errorf("listener %v: %w", e.name, err)
+			}
+			errs <- err
+		}()
+	}
+
+	var err error
+	for range opened {
+		if lerr := <-errs; lerr != nil && err == nil {
+			err = lerr
+			cancel()
+		}
+	}
+	return err
+}
+
+// listenerSpec validates l and returns its endpoint
+func (s *Server) listenerSpec(l Listener) (*endpoint, error) {
+	name := l.Name
+	if name == "" {
+		switch {
+		case l.Listener != nil:
+			name = endpointName(l.Scheme, l.Listener.Addr())
+		case l.PacketConn != nil:
+			name = endpointName(l.Scheme, l.PacketConn.LocalAddr())
+		default:
+			name = l.Scheme + "://" + l.Addr
+		}
+	}
+	if l.Addr == "" && l.Listener == nil && l.PacketConn == nil {
+		return nil, fmt.Errorf("listener %v has no address", name)
+	}
+	if l.PacketConn != nil && l.Scheme != "udp" {
+		return nil, fmt.Errorf("listener %v needs a stream socket", name)
+	}
+	switch l.Scheme {
+	case "tcp", "http":
+		return s.newEndpoint(name, nil), nil
+	case "tls", "https":
+		cfg := l.TLS
+		if cfg == nil {
+			cfg = s.tlsConfig
+		}
+		if cfg == nil {
+			return nil, fmt.Errorf("listener %v needs a TLS config", name)
+		}
+		return s.newEndpoint(name, cfg), nil
+	case "metrics":
+		// metrics listeners have no stats of their own
+		return &endpoint{Server: s, name: name}, nil
+	case "udp":
+		if l.Listener != nil {
+			return nil, fmt.Errorf("listener %v needs a packet socket", name)
+		}
+		return s.newEndpoint(name, nil), nil
+	default:
+		return nil, fmt.Errorf("listener %v has unknown scheme %q", name, l.Scheme)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"powquote/internal/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListeners(t *testing.T) {
+	listeners, err := ParseListeners("tcp://:8080, https://:8443,,udp://127.0.0.1:8082,http://unix:/run/pow.sock")
+	require.NoError(t, err)
+	assert.Equal(t, []Listener{
+		{Name: "tcp://:8080", Scheme: "tcp", Addr: ":8080"},
+		{Name: "https://:8443", Scheme: "https", Addr: ":8443"},
+		{Name: "udp://127.0.0.1:8082", Scheme: "udp", Addr: "127.0.0.1:8082"},
+		{Name: "http://unix:/run/pow.sock", Scheme: "http", Addr: "unix:/run/pow.sock"},
+	}, listeners)
+
+	for _, spec := range []string{":8080", "ftp://:21", "tcp://", "udp://unix:/run/pow.sock"} {
+		_, err := ParseListener(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestServer_Run(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	httpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := New("", WithLogger(discardLogger), WithComplexity(2), WithHandler(fixedHandler))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Run(ctx, []Listener{
+			{Name: "legacy", Scheme: "tcp", Listener: ln},
+			{Scheme: "http", Listener: httpLn},
+			{Scheme: "udp", PacketConn: pc},
+		})
+	}()
+
+	tcpQuote, err := client.New(ln.Addr().String()).FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "resource", tcpQuote.Text)
+
+	udpQuote, err := udpClient(pc.LocalAddr().String()).FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "resource", udpQuote.Text)
+
+	var ch GatewayChallenge
+	require.Equal(t, http.StatusOK, gatewayCall(t, http.MethodGet, "http://"+httpLn.Addr().String()+"/challenge", nil, &ch))
+	// the nonce is shared by all listeners
+	assert.Equal(t, tcpQuote.Challenge.Nonce, ch.Nonce)
+	assert.Equal(t, udpQuote.Challenge.Nonce, ch.Nonce)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("listeners did not stop")
+	}
+
+	stats := srv.ListenerStats()
+	assert.Equal(t, uint64(1), stats["legacy"].Served)
+	assert.Equal(t, uint64(1), stats["http://"+httpLn.Addr().String()].Challenges)
+	assert.Equal(t, uint64(0), stats["http://"+httpLn.Addr().String()].Served)
+	assert.Equal(t, uint64(1), stats["udp://"+pc.LocalAddr().String()].Served)
+	assert.Equal(t, uint64(2), srv.Stats().Served)
+	assert.Equal(t, uint64(3), srv.Stats().Challenges)
+}
+
+func TestServer_RunInvalid(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	srv := New("", WithLogger(discardLogger))
+	err = srv.Run(context.Background(), []Listener{{Scheme: "tls", Addr: "127.0.0.1:0"}})
+	assert.ErrorContains(t, err, "needs a TLS config")
+
+	err = srv.Run(context.Background(), []Listener{
+		{Scheme: "tcp", Addr: "127.0.0.1:0"},
+		{Scheme: "http", Addr: ln.Addr().String()},
+	})
+	assert.ErrorContains(t, err, "error opening listener http://"+ln.Addr().String())
+}
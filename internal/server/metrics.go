@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"powquote/internal/metrics"
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+)
+
+// maxTrackedChallenges limits the clients whose challenge issue time is remembered for the solve time histogram
+const maxTrackedChallenges = 10000
+
+var (
+	handlerBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 30, 300}
+	solveBuckets   = []float64{0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300}
+)
+
+// serverMetrics are the metrics not covered by the Stats counters, which are exported as well
+type serverMetrics struct {
+	registry *metrics.Registry
+	// rejected counts invalid solutions by listener and reason
+	rejected *metrics.Counter
+	handler  *metrics.Histogram
+	solve    *metrics.Histogram
+	issued   issueTimes
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		issued:   issueTimes{times: make(map[string]time.Time)},
+	}
+
+	listenerCounter := func(name, help string, value func(Stats) uint64) {
+		r.CounterFunc(name, help, []string{"listener"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for listener, stats := range s.ListenerStats() {
+				samples = append(samples, metrics.Sample{Labels: []string{listener}, Value: float64(value(stats))})
+			}
+			return samples
+		})
+	}
+	listenerCounter("powquote_connections_total", "Connections, HTTP requests and datagrams received.", func(s Stats) uint64 { return s.Connections })
+	listenerCounter("powquote_challenges_issued_total", "Challenges sent to clients.", func(s Stats) uint64 { return s.Challenges })
+	listenerCounter("powquote_solutions_accepted_total", "Valid solutions.", func(s Stats) uint64 { return s.Accepted })
+	m.rejected = r.Counter("powquote_solutions_rejected_total", "Invalid solutions by reason: invalid_hash, replay, expired_nonce, client_id or server_id.", "listener", "reason")
+	listenerCounter("powquote_served_total", "Resources served.", func(s Stats) uint64 { return s.Served })
+	reasonCounter := func(name, help string, values func(Stats) map[string]uint64) {
+		r.CounterFunc(name, help, []string{"listener", "reason"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for listener, stats := range s.ListenerStats() {
+				for reason, value := range values(stats) {
+					samples = append(samples, metrics.Sample{Labels: []string{listener, reason}, Value: float64(value)})
+				}
+			}
+			return samples
+		})
+	}
+	reasonCounter("powquote_refused_total", "Clients refused before the puzzle by reason.", func(s Stats) map[string]uint64 {
+		return map[string]uint64{
+			"overloaded":   s.Overloaded,
+			"rate_limited": s.RateLimited,
+			"denied":       s.Denied,
+			"banned":       s.Banned,
+		}
+	})
+	reasonCounter("powquote_errors_total", "Exchanges that failed by reason: request_timeout, request_too_large, handshake_error or response_dropped for UDP responses larger than the request.", func(s Stats) map[string]uint64 {
+		return map[string]uint64{
+			"request_timeout":   s.RequestTimeouts,
+			"request_too_large": s.RequestsTooLarge,
+			"handshake_error":   s.HandshakeErrors,
+			"response_dropped":  s.Dropped,
+		}
+	})
+	listenerCounter("powquote_received_bytes_total", "Bytes received from clients, TLS and PROXY protocol headers included.", func(s Stats) uint64 { return s.BytesIn })
+	listenerCounter("powquote_sent_bytes_total", "Bytes sent to clients.", func(s Stats) uint64 { return s.BytesOut })
+	m.handler = r.Histogram("powquote_handler_duration_seconds", "Time spent serving the resource.", handlerBuckets, "listener")
+	m.solve = r.Histogram("powquote_solve_duration_seconds", "Time from issuing a challenge to receiving its valid solution.", solveBuckets, "listener")
+	r.GaugeFunc("powquote_complexity", "Current puzzle complexity.", func() float64 { return float64(s.settings().complexity) })
+	r.GaugeFunc("powquote_protected", "Whether the puzzle is required.", func() float64 {
+		if s.settings().protected {
+			return 1
+		}
+		return 0
+	})
+	r.GaugeFunc("powquote_replay_store_size", "Solutions remembered for replay protection.", func() float64 { return float64(puzzle.ReplayStoreSize()) })
+	r.GaugeFunc("powquote_active_connections", "TCP connections and websocket sessions being served.", func() float64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return float64(len(s.active))
+	})
+	return m
+}
+
+// Metrics returns an HTTP handler exposing the server metrics in the Prometheus text format
+func (s *Server) Metrics() http.Handler {
+	return s.metrics.registry
+}
+
+// serveMetrics serves the metrics on ln until ctx is done
+func (s *Server) serveMetrics(ctx context.Context, ln net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.Metrics())
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: s.requestTimeout,
+		WriteTimeout:      s.ioTimeout,
+		ErrorLog:          s.logger,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	s.logger.Printf("begin serving metrics on %v", ln.Addr())
+	err := srv.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// countRejected counts an invalid solution by the reason
+func (e *endpoint) countRejected(err error) {
+	atomic.AddUint64(&e.stats.Rejected, 1)
+	e.metrics.rejected.Inc(e.name, rejectReason(err))
+}
+
+// countAccepted counts a valid solution and its solve time if the challenge was issued by this server since the last nonce change
+func (e *endpoint) countAccepted(req protocol.QuoteRequest) {
+	atomic.AddUint64(&e.stats.Accepted, 1)
+	if d, ok := e.metrics.issued.redeemed(req.ClientID, req.NonceServer); ok {
+		e.metrics.solve.Observe(d.Seconds(), e.name)
+	}
+}
+
+// serveHandler runs the handler and measures how long it takes
+func (e *endpoint) serveHandler(conn net.Conn) error {
+	started := time.Now()
+	err := e.settings().handler.ServeConn(conn)
+	e.metrics.handler.Observe(time.Since(started).Seconds(), e.name)
+	return err
+}
+
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, puzzle.ErrInvalidHash):
+		return "invalid_hash"
+	case errors.Is(err, puzzle.ErrReplay):
+		return "replay"
+	case errors.Is(err, puzzle.ErrNonce):
+		return "expired_nonce"
+	case errors.Is(err, puzzle.ErrClientID):
+		return "client_id"
+	case errors.Is(err, puzzle.ErrServerID):
+		return "server_id"
+	}
+	return "other"
+}
+
+// issueTimes remembers when each client got its latest challenge with the current server nonce.
+// Solutions carry no timestamp, so this is the only way to measure solve time without keeping state in the protocol
+type issueTimes struct {
+	mu    sync.Mutex
+	nonce uint64
+	times map[string]time.Time
+}
+
+func (t *issueTimes) issued(clientID string, nonce uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if nonce != t.nonce {
+		t.nonce = nonce
+		t.times = make(map[string]time.Time)
+	}
+	if _, ok := t.times[clientID]; ok || len(t.times) < maxTrackedChallenges {
+		t.times[clientID] = time.Now()
+	}
+}
+
+func (t *issueTimes) redeemed(clientID string, nonce uint64) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	at, ok := t.times[clientID]
+	if !ok || nonce != t.nonce {
+		return 0, false
+	}
+	delete(t.times, clientID)
+	return time.Since(at), true
+}
+
+// countingListener counts the bytes of accepted connections into the listener stats
+type countingListener struct {
+	net.Listener
+	stats *counters
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, stats: l.stats}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	stats *counters
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.stats.BytesIn, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddUint64(&c.stats.BytesOut, uint64(n))
+	return n, err
+}
+
+// CloseWrite keeps half-closing available to handlers like Proxy
+func (c *countingConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("connection does not support closing for writing")
+}
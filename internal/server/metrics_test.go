@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"powquote/internal/client"
+	"powquote/internal/protocol"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	metricsLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+
+	srv := New("", WithLogger(discardLogger), WithComplexity(2), WithHandler(fixedHandler))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Run(ctx, []Listener{
+			{Name: "legacy", Scheme: "tcp", Listener: ln},
+			{Scheme: "metrics", Listener: metricsLn},
+		})
+	}()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	_, err = client.New(addr).FetchQuote(context.Background())
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	garbage := protocol.QuoteRequest{ServerID: addr, HashData: protocol.HashData{ClientID: "127.0.0.1", NonceServer: 1}}
+	_, err = conn.Write(append(garbage.Bytes(), '\n'))
+	require.NoError(t, err)
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+
+	resp, err := http.Get("http://" + metricsLn.Addr().String() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	body := string(bs)
+
+	assert.Contains(t, body, "powquote_challenges_issued_total{listener=\"legacy\"} 1\n")
+	assert.Contains(t, body, "powquote_solutions_accepted_total{listener=\"legacy\"} 1\n")
+	assert.Contains(t, body, "powquote_solutions_rejected_total{listener=\"legacy\",reason=\"expired_nonce\"} 1\n")
+	assert.Contains(t, body, "powquote_served_total{listener=\"legacy\"} 1\n")
+	assert.Contains(t, body, "powquote_refused_total{listener=\"legacy\",reason=\"rate_limited\"} 0\n")
+	assert.Contains(t, body, "powquote_errors_total{listener=\"legacy\",reason=\"request_timeout\"} 0\n")
+	assert.Contains(t, body, "powquote_solve_duration_seconds_count{listener=\"legacy\"} 1\n")
+	assert.Contains(t, body, "powquote_handler_duration_seconds_count{listener=\"legacy\"} 1\n")
+	assert.Contains(t, body, "powquote_complexity 2\n")
+	assert.Contains(t, body, "# TYPE powquote_replay_store_size gauge\n")
+	assert.NotContains(t, body, "powquote_received_bytes_total{listener=\"legacy\"} 0\n")
+
+	stats := srv.ListenerStats()
+	assert.NotContains(t, stats, "metrics://"+metricsLn.Addr().String())
+	assert.Greater(t, stats["legacy"].BytesOut, uint64(0))
+}
+
+func TestServer_MetricsHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New("", WithLogger(discardLogger)).Metrics().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "powquote_protected 1\n")
+	assert.Contains(t, rec.Body.String(), "# TYPE powquote_solve_duration_seconds histogram\n")
+}
+
+func TestCountingConn_CloseWrite(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := &countingConn{Conn: local, stats: &counters{}}
+	defer conn.Close()
+
+	// net.Pipe cannot half-close, the connection should stay open
+	assert.Error(t, conn.CloseWrite())
+	go func() { _, _ = remote.Write([]byte("ok")) }()
+	buf := make([]byte, 2)
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(buf))
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"log"
+	"net/netip"
+	"time"
+
+	"powquote/internal/acl"
+	"powquote/internal/ban"
+	"powquote/internal/mtls"
+	"powquote/internal/ratelimit"
+)
+
+type Option func(*Server)
+
+func WithHandler(h Handler) Option {
+	return func(s *Server) {
+		s.config.handler = h
+	}
+}
+
+// WithProtection enables or disables the puzzle; unprotected server serves the resource right away
+func WithProtection(enabled bool) Option {
+	return func(s *Server) {
+		s.config.protected = enabled
+	}
+}
+
+func WithComplexity(complexity int) Option {
+	return func(s *Server) {
+		s.config.complexity = complexity
+	}
+}
+
+// WithNoncePeriod sets how often the server nonce changes, i.e. how long a client has to solve a puzzle
+func WithNoncePeriod(period time.Duration) Option {
+	return func(s *Server) {
+		s.noncePeriod = period
+	}
+}
+
+// WithIOTimeout limits the whole exchange with a single connection
+func WithIOTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.ioTimeout = timeout
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithDrainTimeout limits how long in-flight connections may run after the server has stopped accepting
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.drainTimeout = timeout
+	}
+}
+
+// WithMaxConnections limits the number of connections handled concurrently; clients above the limit get an overload response.
+// Zero or less means no limit
+func WithMaxConnections(max int) Option {
+	return func(s *Server) {
+		if max <= 0 {
+			s.slots = nil
+			return
+		}
+		s.slots = make(chan struct{}, max)
+	}
+}
+
+// WithRateLimiter limits how often a client IP or subnet may connect; the check happens before reading the request
+func WithRateLimiter(limiter *ratelimit.ClientLimiter) Option {
+	return func(s *Server) {
+		s.config.limiter = limiter
+	}
+}
+
+// WithSilentRejects makes the server close rate limited and overloaded connections without a response
+func WithSilentRejects(silent bool) Option {
+	return func(s *Server) {
+		s.silentRejects = silent
+	}
+}
+
+// WithRequestTimeout limits the time a client has to send its request line after connecting
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = timeout
+	}
+}
+
+// WithMaxRequestBytes limits how many bytes are read from a client before it sends a complete request
+func WithMaxRequestBytes(max int) Option {
+	return func(s *Server) {
+		s.maxRequestBytes = max
+	}
+}
+
+// WithACL drops denylisted clients right after accepting and serves allowlisted ones without the puzzle and rate limits
+func WithACL(a *acl.ACL) Option {
+	return func(s *Server) {
+		s.config.acl = a
+	}
+}
+
+// WithBans bans clients that keep sending invalid or replayed solutions; banned clients are disconnected right after accepting
+func WithBans(m *ban.Manager) Option {
+	return func(s *Server) {
+		s.bans = m
+	}
+}
+
+type ProxyProtocol struct {
+	// Optional accepts connections without a PROXY header too, e.g. direct connections bypassing the load balancer
+	Optional bool
+	// Trusted are the addresses of the proxies; headers from other peers are not read, so they cannot pose as other clients.
+	// No peer is trusted when empty, so it has to be set for headers to be used
+	Trusted []netip.Prefix
+}
+
+// WithProxyProtocol expects connections to start with a PROXY protocol v1 or v2 header (as sent by HAProxy or AWS NLB)
+// and uses the client and destination addresses from it for puzzle checks, rate limiting and logging
+func WithProxyProtocol(p ProxyProtocol) Option {
+	return func(s *Server) {
+		s.proxyProtocol = &p
+	}
+}
+
+// WithIdentityKey sets the key challenges are signed with; a random key is generated by default
+func WithIdentityKey(key ed25519.PrivateKey) Option {
+	return func(s *Server) {
+		s.identityKey = key
+	}
+}
+
+// WithServerIDs sets the names clients may use as the server id, e.g. DNS names the server is reachable by.
+// The first one is advertised in challenges. Names must pass protocol.ValidServerID
+func WithServerIDs(names ...string) Option {
+	return func(s *Server) {
+		s.names = names
+	}
+}
+
+// WithTLS serves connections over TLS, see tlsutil.ServerConfig.
+// The handshake is limited by the request timeout and happens after the PROXY protocol header if that is enabled too
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithClientPolicies exempts clients with verified TLS certificates from the puzzle or changes their complexity and rate limits.
+// Client certificates are only verified if the TLS config has client CAs, see tlsutil.ServerConfig
+func WithClientPolicies(policies *mtls.Policies) Option {
+	return func(s *Server) {
+		s.clientPolicies = policies
+	}
+}
+
+// WithSingleConnection makes the client send the solution on the connection it got the challenge from, the challenge is then terminated by a line break.
+// The resource is served on that connection, which lets handlers like Proxy take it over
+func WithSingleConnection() Option {
+	return func(s *Server) {
+		s.singleConnection = true
+	}
+}
+
+// WithLogLevel hides the messages about clients below the level, see LogLevel
+func WithLogLevel(level LogLevel) Option {
+	return func(s *Server) {
+		s.config.logLevel = level
+	}
+}
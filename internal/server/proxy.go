@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"powquote/internal/protocol"
+)
+
+// ProxyStats is a snapshot of the proxy counters
+type ProxyStats struct {
+	Sessions       uint64
+	UpstreamErrors uint64
+	// BytesIn is sent by clients to the upstream, BytesOut the other way
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+func (s ProxyStats) String() string {
+	return fmt.Sprintf("sessions = %v, upstream errors = %v, bytes in = %v, bytes out = %v", s.Sessions, s.UpstreamErrors, s.BytesIn, s.BytesOut)
+}
+
+// Proxy is a Handler connecting clients that have passed the puzzle to an upstream TCP service.
+// It needs the single connection flow, see WithSingleConnection: the client gets protocol.Accepted and a line break,
+// then everything it sends after the solution goes to the upstream and back
+type Proxy struct {
+	Upstream    string
+	DialTimeout time.Duration
+	// IdleTimeout closes sessions with no data in either direction for that long
+	IdleTimeout time.Duration
+	Logger      *log.Logger
+
+	stats ProxyStats
+}
+
+func NewProxy(upstream string) *Proxy {
+	return &Proxy{
+		Upstream:    upstream,
+		DialTimeout: time.Second * 5,
+		IdleTimeout: time.Minute * 5,
+		Logger:      log.Default(),
+	}
+}
+
+// Stats returns the current values of the proxy counters
+func (p *Proxy) Stats() ProxyStats {
+	return ProxyStats{
+		Sessions:       atomic.LoadUint64(&p.stats.Sessions),
+		UpstreamErrors: atomic.LoadUint64(&p.stats.UpstreamErrors),
+		BytesIn:        atomic.LoadUint64(&p.stats.BytesIn),
+		BytesOut:       atomic.LoadUint64(&p.stats.BytesOut),
+	}
+}
+
+func (p *Proxy) ServeConn(conn net.Conn) error {
+	upstream, err := net.DialTimeout("tcp", p.Upstream, p.DialTimeout)
+	if err != nil {
+		atomic.AddUint64(&p.stats.UpstreamErrors, 1)
+		return fmt.Errorf("error connecting to upstream: %w", err)
+	}
+	defer upstream.Close()
+
+	if _, err := conn.Write(append(append([]byte{}, protocol.Accepted...), '\n')); err != nil {
+		return err
+	}
+	atomic.AddUint64(&p.stats.Sessions, 1)
+
+	// last is the time of the latest transfer in either direction, in unix nanoseconds
+	last := time.Now().UnixNano()
+	var in, out uint64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	var inErr, outErr error
+	go func() {
+		defer wg.Done()
+		inErr = p.pipe(upstream, conn, &in, &p.stats.BytesIn, &last)
+	}()
+	go func() {
+		defer wg.Done()
+		outErr = p.pipe(conn, upstream, &out, &p.stats.BytesOut, &last)
+	}()
+	wg.Wait()
+
+	p.Logger.Printf("(%v) proxied session closed: %v bytes in, %v bytes out", conn.RemoteAddr(), in, out)
+	if inErr != nil {
+		return inErr
+	}
+	return outErr
+}
+
+// pipe copies src to dst until src is done, then closes dst for writing.
+// A read times out only when the whole session has been idle, so one-way transfers are not cut off
+func (p *Proxy) pipe(dst, src net.Conn, session, total *uint64, last *int64) error {
+	buf := make([]byte, 32*1024)
+	for {
+		_ = src.SetReadDeadline(time.Now().Add(p.IdleTimeout))
+		n, err := src.Read(buf)
+		if n > 0 {
+			atomic.StoreInt64(last, time.Now().UnixNano())
+			_ = dst.SetWriteDeadline(time.Now().Add(p.IdleTimeout))
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				_ = src.Close()
+				return werr
+			}
+			atomic.AddUint64(session, uint64(n))
+			atomic.AddUint64(total, uint64(n))
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && time.Since(time.Unix(0, atomic.LoadInt64(last))) < p.IdleTimeout {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			if cw, ok := dst.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+				return nil
+			}
+			_ = dst.Close()
+			return nil
+		}
+		if err != nil {
+			// unblock the other direction
+			_ = dst.Close()
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return errors.New("idle timeout exceeded")
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+	}
+}
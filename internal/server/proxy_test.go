@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"powquote/internal/acl"
+	"powquote/internal/client"
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEcho runs an upstream writing back everything it reads
+func startEcho(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestProxy(t *testing.T) {
+	proxy := NewProxy(startEcho(t))
+	proxy.Logger = discardLogger
+	addr := start(t, WithComplexity(2), WithSingleConnection(), WithHandler(proxy))
+
+	conn, err := client.New(addr).Dial(context.Background())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(interface{ CloseWrite() error }).CloseWrite())
+
+	bs, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(bs))
+	_ = conn.Close()
+
+	require.Eventually(t, func() bool {
+		return proxy.Stats() == ProxyStats{Sessions: 1, BytesIn: 4, BytesOut: 4}
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestProxy_IdleTimeout(t *testing.T) {
+	proxy := NewProxy(startEcho(t))
+	proxy.Logger = discardLogger
+	proxy.IdleTimeout = time.Millisecond * 100
+	addr := start(t, WithComplexity(1), WithSingleConnection(), WithHandler(proxy))
+
+	conn, err := client.New(addr).Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	started := time.Now()
+	_, _ = io.ReadAll(conn)
+	assert.Less(t, time.Since(started), time.Second, "idle session is closed")
+}
+
+func TestProxy_Exempt(t *testing.T) {
+	rules, err := acl.Parse(strings.NewReader("allow 127.0.0.1"))
+	require.NoError(t, err)
+	proxy := NewProxy(startEcho(t))
+	proxy.Logger = discardLogger
+	addr := start(t, WithComplexity(6), WithSingleConnection(), WithHandler(proxy), WithACL(rules))
+
+	conn, err := client.New(addr).Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	bs := make([]byte, 4)
+	_, err = io.ReadFull(conn, bs)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(bs), "the hello line is not proxied")
+}
+
+func TestProxy_UpstreamDown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	upstream := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	proxy := NewProxy(upstream)
+	proxy.Logger = discardLogger
+	addr := start(t, WithComplexity(1), WithSingleConnection(), WithHandler(proxy))
+
+	_, err = client.New(addr).Dial(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), proxy.Stats().UpstreamErrors)
+}
+
+func TestProxy_Pipelined(t *testing.T) {
+	rules, err := acl.Parse(strings.NewReader("allow 127.0.0.1"))
+	require.NoError(t, err)
+	proxy := NewProxy(startEcho(t))
+	proxy.Logger = discardLogger
+	addr := start(t, WithSingleConnection(), WithHandler(proxy), WithACL(rules))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Write([]byte("HELLO\nping"))
+	require.NoError(t, err)
+
+	want := string(protocol.Accepted) + "\nping"
+	bs := make([]byte, len(want))
+	_, err = io.ReadFull(conn, bs)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(bs), "data sent right after the request is proxied")
+}
+
+func TestProxy_SolveTime(t *testing.T) {
+	proxy := NewProxy(startEcho(t))
+	proxy.Logger = discardLogger
+	addr := start(t, WithComplexity(1), WithSingleConnection(), WithHandler(proxy), WithRequestTimeout(time.Millisecond*50))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Write(append(protocol.Hello, '\n'))
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	require.NoError(t, err)
+	challenge, err := protocol.ChallengeFromBytes(bytes.TrimSuffix(line, []byte("\n")))
+	require.NoError(t, err)
+
+	// a slow solver takes longer than the request timeout
+	time.Sleep(time.Millisecond * 150)
+	req := protocol.QuoteRequest{
+		ServerID: challenge.ServerID,
+		HashData: protocol.HashData{ClientID: challenge.ClientAddr, NonceServer: challenge.Nonce, NonceClient: puzzle.GenerateNonceOnce()},
+	}
+	puzzle.Solve(&req.HashData, challenge)
+	_, err = conn.Write(append(req.Bytes(), '\n'))
+	require.NoError(t, err)
+
+	line, err = r.ReadBytes('\n')
+	require.NoError(t, err)
+	assert.Equal(t, string(protocol.Accepted)+"\n", string(line))
+}
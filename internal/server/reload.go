@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+
+	"powquote/internal/acl"
+	"powquote/internal/ratelimit"
+)
+
+// settings are the part of the server configuration that can change while it is running
+type settings struct {
+	handler    Handler
+	protected  bool
+	complexity int
+	limiter    *ratelimit.ClientLimiter
+	acl        *acl.ACL
+	logLevel   LogLevel
+	// loweredComplexity is accepted for solutions to the challenges issued for loweredNonce, before a reload raised the complexity
+	loweredComplexity int
+	loweredNonce      uint64
+}
+
+// settings returns the settings in use; a connection takes them once, so a reload does not change it halfway
+func (s *Server) settings() settings {
+	return s.live.Load().(settings)
+}
+
+// Reload applies options to the running server; connections admitted afterwards use the new values.
+// Only WithHandler, WithProtection, WithComplexity, WithRateLimiter, WithACL and WithLogLevel can be reloaded,
+// other options are ignored. Replay protection, bans and connections are kept, and so are nonces unless the complexity is raised
+func (s *Server) Reload(opts ...Option) {
+	cur := s.settings()
+	next := &Server{config: cur}
+	for _, opt := range opts {
+		opt(next)
+	}
+	if next.config.complexity > cur.complexity {
+		// a new nonce tells the challenges issued from now on from the ones that may still be solved at the old complexity
+		next.config.loweredComplexity = cur.complexity
+		next.config.loweredNonce = s.nonces.Current()
+		s.nonces.Rotate()
+	}
+	s.live.Store(next.config)
+}
+
+// LogLevel selects which messages about clients are logged; server start, stop and configuration messages are always logged
+type LogLevel int
+
+const (
+	// LogInfo logs every step of the exchange with a client
+	LogInfo LogLevel = iota
+	// LogWarn logs refused clients, invalid requests and solutions, and errors
+	LogWarn
+	// LogError logs errors only
+	LogError
+)
+
+// ParseLogLevel parses info, warn or error
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch s {
+	case "info":
+		return LogInfo, nil
+	case "warn":
+		return LogWarn, nil
+	case "error":
+		return LogError, nil
+	}
+	return LogInfo, fmt.Errorf("unknown log level %q; should be info, warn or error", s)
+}
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// logf logs a message at the level if the current log level allows it
+func (s *Server) logf(level LogLevel, format string, args ...any) {
+	if level >= s.settings().logLevel {
+		s.logger.Printf(format, args...)
+	}
+}
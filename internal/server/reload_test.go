@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"powquote/internal/acl"
+	"powquote/internal/client"
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Reload(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	var logs syncBuffer
+	srv := New("", WithLogger(log.New(&logs, "", 0)), WithComplexity(2), WithHandler(fixedHandler))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	addr := ln.Addr().String()
+
+	q, err := client.New(addr).FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, q.Challenge.Complexity)
+	nonce := q.Challenge.Nonce
+
+	srv.Reload(WithComplexity(3), WithHandler(HandlerFunc(func(conn net.Conn) error {
+		_, err := conn.Write([]byte("reloaded"))
+		return err
+	})), WithLogLevel(LogWarn), WithLogger(discardLogger))
+	q, err = client.New(addr).FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, q.Challenge.Complexity)
+	assert.Equal(t, "reloaded", q.Text)
+	assert.NotEqual(t, nonce, q.Challenge.Nonce, "raising the complexity starts a new nonce")
+
+	deny, err := acl.Parse(strings.NewReader("deny 127.0.0.1"))
+	require.NoError(t, err)
+	srv.Reload(WithProtection(false), WithACL(deny))
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	bs, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, bs)
+
+	// only warnings are logged after the reload, to the logger set in New
+	assert.Contains(t, logs.String(), "(127.0.0.1:")
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(logs.String()), "denied"), logs.String())
+}
+
+func TestServer_ReloadRaisedComplexity(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	srv := New("", WithLogger(discardLogger), WithComplexity(1), WithHandler(fixedHandler))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	addr := ln.Addr().String()
+
+	solve := func(challenge protocol.Challenge) []byte {
+		req := protocol.QuoteRequest{
+			ServerID: challenge.ServerID,
+			HashData: protocol.HashData{ClientID: challenge.ClientAddr, NonceServer: challenge.Nonce, NonceClient: 1},
+		}
+		puzzle.Solve(&req.HashData, challenge)
+		return req.Bytes()
+	}
+
+	before, err := protocol.ChallengeFromBytes([]byte(say(t, addr, protocol.Hello)))
+	require.NoError(t, err)
+	srv.Reload(WithComplexity(40))
+	assert.Equal(t, "resource", say(t, addr, solve(before)), "challenges issued before the raise are solved at the old complexity")
+
+	after, err := protocol.ChallengeFromBytes([]byte(say(t, addr, protocol.Hello)))
+	require.NoError(t, err)
+	require.Equal(t, 40, after.Complexity)
+	require.NotEqual(t, before.Nonce, after.Nonce)
+	after.Complexity = before.Complexity
+	assert.Equal(t, string(protocol.InvalidSolution), say(t, addr, solve(after)), "challenges issued after the raise are not")
+}
+
+// syncBuffer is a bytes.Buffer safe to log to from many goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, level := range []LogLevel{LogInfo, LogWarn, LogError} {
+		parsed, err := ParseLogLevel(level.String())
+		require.NoError(t, err)
+		assert.Equal(t, level, parsed)
+	}
+	_, err := ParseLogLevel("debug")
+	assert.Error(t, err)
+}
@@ -0,0 +1,768 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"powquote/internal/acl"
+	"powquote/internal/ban"
+	"powquote/internal/listen"
+	"powquote/internal/mtls"
+	"powquote/internal/protocol"
+	"powquote/internal/proxyproto"
+	"powquote/internal/puzzle"
+)
+
+const (
+	minAcceptBackoff = time.Millisecond * 5
+	maxAcceptBackoff = time.Second
+	rejectTimeout    = time.Millisecond * 100
+	// maxRejecting limits the rejected connections being written a response at once
+	maxRejecting       = 64
+	limiterPrunePeriod = time.Minute
+)
+
+type Server struct {
+	addr string
+	// config collects the reloadable settings from the options, live holds the ones in use, see Reload
+	config       settings
+	live         atomic.Value
+	noncePeriod  time.Duration
+	ioTimeout    time.Duration
+	drainTimeout time.Duration
+	// requestTimeout and maxRequestBytes limit reading the request line
+	requestTimeout  time.Duration
+	maxRequestBytes int
+	logger          *log.Logger
+
+	nonces interface {
+		Current() uint64
+		Previous() uint64
+		Rotate()
+		Start(context.Context)
+	}
+	metrics *serverMetrics
+	// slots limits the number of concurrent connections when not nil
+	slots chan struct{}
+	// rejecting limits the goroutines writing responses to rejected connections
+	rejecting     chan struct{}
+	bans          *ban.Manager
+	proxyProtocol *ProxyProtocol
+	identityKey   ed25519.PrivateKey
+	names         []string
+	tlsConfig     *tls.Config
+	silentRejects bool
+	// singleConnection keeps the connection open after the challenge for the solution
+	singleConnection bool
+	// clientPolicies apply to clients with verified TLS certificates
+	clientPolicies *mtls.Policies
+
+	mu sync.Mutex
+	// active are the connections and websocket sessions being served
+	active map[io.Closer]struct{}
+	// endpoints are the counters of the listeners by name
+	endpoints map[string]*counters
+	wg        sync.WaitGroup
+	// serving is the number of running listeners, stopJobs stops the jobs they share
+	serving  int
+	stopJobs context.CancelFunc
+}
+
+func New(addr string, opts ...Option) *Server {
+	s := &Server{
+		addr:            addr,
+		config:          settings{handler: QuoteHandler, protected: true, complexity: 5},
+		noncePeriod:     time.Minute * 5,
+		ioTimeout:       time.Second * 30,
+		drainTimeout:    time.Second * 10,
+		requestTimeout:  time.Second * 5,
+		maxRequestBytes: protocol.MaxRequestLength,
+		logger:          log.Default(),
+		active:          make(map[io.Closer]struct{}),
+		endpoints:       make(map[string]*counters),
+		rejecting:       make(chan struct{}, maxRejecting),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.identityKey == nil {
+		_, s.identityKey, _ = ed25519.GenerateKey(rand.Reader)
+	}
+	s.nonces = puzzle.NewNonceGenerator(s.noncePeriod)
+	s.live.Store(s.config)
+	s.metrics = newServerMetrics(s)
+	return s
+}
+
+// serverIDs returns the ids accepted in quote requests, the advertised one goes first.
+// Without configured names the key fingerprint is advertised, and the listen address is accepted for older clients
+func (s *Server) serverIDs(local net.Addr) puzzle.ServerIDs {
+	fingerprint := puzzle.KeyFingerprint(s.PublicKey())
+	if len(s.names) == 0 {
+		return puzzle.ServerIDs{fingerprint, local.String()}
+	}
+	return append(append(puzzle.ServerIDs{}, s.names...), fingerprint)
+}
+
+// PublicKey returns the key clients can verify challenges with
+func (s *Server) PublicKey() ed25519.PublicKey {
+	return s.identityKey.Public().(ed25519.PublicKey)
+}
+
+// ListenAndServe listens on the server address, host:port or unix:/path, and serves connections until ctx is done.
+// In-flight connections are then given the drain timeout to finish, see Serve
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := listen.Listen(s.addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeContext(ctx, ln)
+}
+
+// ServeContext works like Serve but stops when ctx is done, e.g. for listeners inherited from systemd
+func (s *Server) ServeContext(ctx context.Context, ln net.Listener) error {
+	return s.listenerEndpoint(ln).serveContext(ctx, ln)
+}
+
+func (e *endpoint) serveContext(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		e.logger.Printf("stopping listening on %v", ln.Addr())
+		_ = ln.Close()
+	}()
+
+	err := e.serve(ln)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Serve accepts connections on ln until it is closed.
+// Before returning it waits for in-flight connections to finish; the ones still running after the drain timeout are closed forcibly
+func (s *Server) Serve(ln net.Listener) error {
+	return s.listenerEndpoint(ln).serve(ln)
+}
+
+// listenerEndpoint is the endpoint of a TCP listener started without a Listener spec, with the server TLS config
+func (s *Server) listenerEndpoint(ln net.Listener) *endpoint {
+	if s.tlsConfig != nil {
+		return s.newEndpoint(endpointName("tls", ln.Addr()), s.tlsConfig)
+	}
+	return s.newEndpoint(endpointName("tcp", ln.Addr()), nil)
+}
+
+func (e *endpoint) serve(ln net.Listener) error {
+	defer e.start()()
+	defer func() {
+		if err := e.drain(); err != nil {
+			e.logger.Print(err)
+		}
+	}()
+
+	ln = e.wrap(ln)
+
+	e.logger.Printf("begin listening on %v; DoS protected = %v, complexity = %v", ln.Addr(), e.settings().protected, e.settings().complexity)
+
+	var backoff time.Duration
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			// e.g. out of file descriptors, which frees up as connections finish
+			backoff = nextBackoff(backoff)
+			e.logf(LogError, "error accepting connection: %v; retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		a, admitted, ok := e.precheck(conn)
+		if !ok {
+			continue
+		}
+		if !e.acquire() {
+			e.reject(conn)
+			continue
+		}
+		e.track(conn)
+		go func() {
+			defer e.release()
+			defer e.untrack(conn)
+			if admitted {
+				ok = e.completeHandshake(conn)
+			} else {
+				a, ok = e.admit(conn)
+			}
+			if ok {
+				e.handleConnection(conn, a)
+			}
+		}()
+	}
+}
+
+// start runs the jobs shared by the listeners, nonce rotation and limiter pruning, while at least one of them is serving.
+// The returned function is called when a listener stops
+func (s *Server) start() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.serving++; s.serving == 1 {
+		var ctx context.Context
+		ctx, s.stopJobs = context.WithCancel(context.Background())
+		go s.nonces.Start(ctx)
+		go s.pruneLimiter(ctx)
+	}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.serving--; s.serving == 0 {
+			s.stopJobs()
+		}
+	}
+}
+
+// wrap adds the PROXY protocol and TLS layers to the listener if they are enabled
+func (e *endpoint) wrap(ln net.Listener) net.Listener {
+	ln = &countingListener{Listener: ln, stats: e.stats}
+	if e.proxyProtocol != nil {
+		ln = &proxyproto.Listener{Listener: ln, HeaderTimeout: e.requestTimeout, Optional: e.proxyProtocol.Optional, Trusted: e.proxyProtocol.Trusted}
+	}
+	if e.tlsConfig != nil {
+		ln = tls.NewListener(ln, e.tlsConfig)
+	}
+	return ln
+}
+
+// admission is how an admitted connection is served
+type admission struct {
+	// exempt connections get the resource without the puzzle, either allowed clients or all of them when protection is off
+	exempt     bool
+	complexity int
+	// loweredComplexity is accepted for solutions to challenges for loweredNonce, issued before a reload raised the complexity
+	loweredComplexity int
+	loweredNonce      uint64
+}
+
+// minComplexity returns the lowest complexity a solution for the server nonce is accepted with
+func (a admission) minComplexity(nonce uint64) int {
+	if nonce == a.loweredNonce && a.loweredComplexity < a.complexity {
+		return a.loweredComplexity
+	}
+	return a.complexity
+}
+
+// refusal is why a client is not admitted
+type refusal int
+
+const (
+	notRefused refusal = iota
+	refusedDenied
+	refusedBanned
+	refusedRateLimited
+)
+
+// precheck applies the checks that need the peer address only before the connection takes a slot, so refused clients cannot use them up.
+// Without a PROXY header or client policies that is the whole admission and it reports admitted; refused connections are closed
+func (e *endpoint) precheck(conn net.Conn) (a admission, admitted bool, ok bool) {
+	if e.proxyProtocol != nil {
+		// the address is in the header, which is read after taking a slot
+		return a, false, true
+	}
+	remote := conn.RemoteAddr().String()
+	addr, hasIP := remoteIP(conn)
+
+	if e.tlsConfig != nil && e.clientPolicies != nil {
+		// a client certificate may lift bans and rate limits, only the deny list is certain before the handshake
+		if e.aclVerdict(e.settings(), remote, addr, hasIP) == acl.Deny {
+			_ = conn.Close()
+			return a, false, false
+		}
+		return a, false, true
+	}
+
+	a, r := e.admitClient(remote, addr, hasIP, nil, true)
+	if r != notRefused {
+		e.refuse(conn, r)
+		return a, false, false
+	}
+	return a, true, true
+}
+
+// admit completes the transport handshake (PROXY protocol header or TLS) and applies the allow and deny lists, client policies, bans and rate limits.
+// Connections that are not admitted are closed
+func (e *endpoint) admit(conn net.Conn) (admission, bool) {
+	if !e.completeHandshake(conn) {
+		return admission{}, false
+	}
+
+	var state *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		cs := tlsConn.ConnectionState()
+		state = &cs
+	}
+	addr, hasIP := remoteIP(conn)
+
+	a, r := e.admitClient(conn.RemoteAddr().String(), addr, hasIP, state, true)
+	if r != notRefused {
+		e.refuse(conn, r)
+		return a, false
+	}
+	return a, true
+}
+
+// completeHandshake runs the transport handshake and closes the connection if it fails
+func (e *endpoint) completeHandshake(conn net.Conn) bool {
+	if err := e.handshake(conn); err != nil {
+		e.logf(LogWarn, "(%v) handshake error: %v", conn.RemoteAddr(), err)
+		atomic.AddUint64(&e.stats.HandshakeErrors, 1)
+		_ = conn.Close()
+		return false
+	}
+	return true
+}
+
+// refuse closes a connection that is not admitted, rate limited clients are told so
+func (s *Server) refuse(conn net.Conn, r refusal) {
+	if r == refusedRateLimited {
+		s.rejectWith(conn, protocol.RateLimited)
+		return
+	}
+	_ = conn.Close()
+}
+
+// admitClient decides how to serve a client by its address and TLS state, both optional; remote is only used in logs.
+// Rate limits are skipped unless limit is set, for clients whose address is not verified yet, see rateLimited
+func (e *endpoint) admitClient(remote string, addr netip.Addr, hasIP bool, state *tls.ConnectionState, limit bool) (admission, refusal) {
+	cur := e.settings()
+	a := admission{exempt: !cur.protected, complexity: cur.complexity, loweredComplexity: cur.loweredComplexity, loweredNonce: cur.loweredNonce}
+
+	verdict := e.aclVerdict(cur, remote, addr, hasIP)
+	if verdict == acl.Deny {
+		return a, refusedDenied
+	}
+	if verdict == acl.Allow {
+		a.exempt = true
+		return a, notRefused
+	}
+
+	subject, policy := e.clientPolicy(remote, state)
+	if policy != nil {
+		if policy.Exempt {
+			a.exempt = true
+			return a, notRefused
+		}
+		if policy.Complexity != nil {
+			a.complexity = *policy.Complexity
+			a.loweredNonce = 0
+		}
+	}
+
+	if e.bans != nil && hasIP {
+		if _, banned := e.bans.Banned(addr); banned {
+			atomic.AddUint64(&e.stats.Banned, 1)
+			return a, refusedBanned
+		}
+	}
+
+	if !limit {
+		return a, notRefused
+	}
+	if policy != nil && policy.HasQuota() {
+		if !policy.Allow(subject) {
+			atomic.AddUint64(&e.stats.RateLimited, 1)
+			e.logf(LogWarn, "(%v) rate limited", remote)
+			return a, refusedRateLimited
+		}
+		return a, notRefused
+	}
+	if hasIP && e.rateLimited(cur, remote, addr) {
+		return a, refusedRateLimited
+	}
+	return a, notRefused
+}
+
+// rateLimited takes a token from the client limiter for addr and reports whether there was none
+func (e *endpoint) rateLimited(cur settings, remote string, addr netip.Addr) bool {
+	if cur.limiter == nil || cur.limiter.Allow(addr) {
+		return false
+	}
+	atomic.AddUint64(&e.stats.RateLimited, 1)
+	e.logf(LogWarn, "(%v) rate limited", remote)
+	return true
+}
+
+// aclVerdict checks the client address against the allow and deny lists and counts denied clients
+func (e *endpoint) aclVerdict(cur settings, remote string, addr netip.Addr, hasIP bool) acl.Verdict {
+	if cur.acl == nil || !hasIP {
+		return acl.None
+	}
+	verdict := cur.acl.Check(addr)
+	if verdict == acl.Deny {
+		atomic.AddUint64(&e.stats.Denied, 1)
+		e.logf(LogWarn, "(%v) denied", remote)
+	}
+	return verdict
+}
+
+// clientPolicy returns the subject of the verified client certificate and the policy for it, if any
+func (e *endpoint) clientPolicy(remote string, state *tls.ConnectionState) (string, *mtls.Policy) {
+	if e.clientPolicies == nil || state == nil {
+		return "", nil
+	}
+	subject, policy, err := e.clientPolicies.Lookup(*state)
+	if err != nil || policy == nil {
+		return "", nil
+	}
+	atomic.AddUint64(&e.stats.Certified, 1)
+	e.logf(LogInfo, "(%v) client certificate %q", remote, subject)
+	return subject, policy
+}
+
+// handshake runs the handshake of wrapped connections under the request timeout
+func (s *Server) handshake(conn net.Conn) error {
+	hs, ok := conn.(interface{ Handshake() error })
+	if !ok {
+		return nil
+	}
+	if err := conn.SetDeadline(time.Now().Add(s.requestTimeout)); err != nil {
+		return err
+	}
+	return hs.Handshake()
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return minAcceptBackoff
+	}
+	if backoff *= 2; backoff > maxAcceptBackoff {
+		return maxAcceptBackoff
+	}
+	return backoff
+}
+
+// acquire takes a connection slot if the number of concurrent connections is limited
+func (s *Server) acquire() bool {
+	if s.slots == nil {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// reject tells the client to come back later without spawning a handler
+func (e *endpoint) reject(conn net.Conn) {
+	atomic.AddUint64(&e.stats.Overloaded, 1)
+	e.logf(LogWarn, "(%v) too many connections, rejecting", conn.RemoteAddr())
+	e.rejectWith(conn, protocol.Overloaded)
+}
+
+// rejectWith writes the response and closes the connection in the background, so a slow client does not hold up the accept loop.
+// Beyond maxRejecting connections at once the rest are closed without a response
+func (s *Server) rejectWith(conn net.Conn, response []byte) {
+	if s.silentRejects {
+		_ = conn.Close()
+		return
+	}
+	select {
+	case s.rejecting <- struct{}{}:
+		go func() {
+			defer func() { <-s.rejecting }()
+			s.closeWith(conn, response)
+		}()
+	default:
+		_ = conn.Close()
+	}
+}
+
+// closeWith writes a short response unless rejects are silent and closes the connection
+func (s *Server) closeWith(conn net.Conn, response []byte) {
+	if !s.silentRejects {
+		// both deadlines, as writing to a TLS connection may need to complete the handshake
+		if err := conn.SetDeadline(time.Now().Add(rejectTimeout)); err == nil {
+			_, _ = conn.Write(response)
+		}
+	}
+	_ = conn.Close()
+}
+
+// strike counts an invalid request against the client; clients whose address may be spoofed are not struck, see bufferConn
+func (s *Server) strike(conn net.Conn) {
+	if s.bans == nil {
+		return
+	}
+	if bc, ok := conn.(*bufferConn); ok && bc.unverified {
+		return
+	}
+	addr, ok := remoteIP(conn)
+	if !ok {
+		return
+	}
+	if until, banned := s.bans.Strike(addr); banned {
+		s.logf(LogWarn, "(%v) banned until %v", conn.RemoteAddr(), until.Format(time.RFC3339))
+	}
+}
+
+func (s *Server) pruneLimiter(ctx context.Context) {
+	ticker := time.NewTicker(limiterPrunePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if limiter := s.settings().limiter; limiter != nil {
+				limiter.Prune()
+			}
+		}
+	}
+}
+
+func (s *Server) track(conn io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active[conn] = struct{}{}
+	s.wg.Add(1)
+}
+
+func (s *Server) untrack(conn io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.active, conn)
+	s.wg.Done()
+}
+
+func (s *Server) drain() error {
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(s.drainTimeout):
+	}
+
+	s.mu.Lock()
+	left := make([]io.Closer, 0, len(s.active))
+	for conn := range s.active {
+		left = append(left, conn)
+	}
+	s.mu.Unlock()
+	// closing a websocket writes a close frame, so not under the lock
+	for _, conn := range left {
+		_ = conn.Close()
+	}
+
+	<-drained
+	return fmt.Errorf("drain timeout exceeded, %v connections closed forcibly", len(left))
+}
+
+// handleConnection runs the puzzle exchange with the admitted complexity; exempt clients get the resource right away
+func (e *endpoint) handleConnection(conn net.Conn, a admission) {
+	defer func() {
+		addr := conn.RemoteAddr()
+		if err := conn.Close(); err != nil {
+			e.logf(LogError, "(%v) error closing connection: %v", addr, err)
+			return
+		}
+		e.logf(LogInfo, "(%v) connection closed by server", addr)
+	}()
+
+	e.logf(LogInfo, "(%v) connected", conn.RemoteAddr())
+	atomic.AddUint64(&e.stats.Connections, 1)
+	conn = &readerConn{Conn: conn, r: bufio.NewReaderSize(conn, e.maxRequestBytes)}
+
+	deadline := time.Now().Add(e.ioTimeout)
+	if err := conn.SetDeadline(deadline); err != nil {
+		e.logf(LogError, "(%v) error setting deadline: %v", conn.RemoteAddr(), err)
+	}
+
+	if a.exempt {
+		// the client starts the single connection flow with a request all the same
+		if e.singleConnection {
+			if _, ok := e.nextRequest(conn, e.requestTimeout, deadline); !ok {
+				return
+			}
+		}
+		e.serveResource(conn)
+		return
+	}
+
+	req, ok := e.nextRequest(conn, e.requestTimeout, deadline)
+	if !ok {
+		return
+	}
+
+	switch req := req.(type) {
+	case protocol.ChallengeRequest:
+		challenge := protocol.Challenge{
+			Nonce:      e.nonces.Current(),
+			Complexity: a.complexity,
+		}
+		e.logf(LogInfo, "(%v) challenge request", conn.RemoteAddr())
+		e.issue(&challenge, conn.RemoteAddr(), conn.LocalAddr())
+		if !e.singleConnection {
+			e.writeResponse(conn, challenge.Bytes())
+			return
+		}
+
+		e.writeResponse(conn, append(challenge.Bytes(), '\n'))
+		// solving takes longer than sending a request, the client has the rest of the I/O timeout
+		next, ok := e.nextRequest(conn, e.ioTimeout, deadline)
+		if !ok {
+			return
+		}
+		quoteReq, ok := next.(protocol.QuoteRequest)
+		if !ok {
+			e.writeResponse(conn, []byte("send the solution to the challenge"))
+			return
+		}
+		e.redeem(conn, a, quoteReq)
+	case protocol.QuoteRequest:
+		e.redeem(conn, a, req)
+	}
+}
+
+// issue fills in the client id of the remote peer and the server id of the local address and signs the challenge
+func (e *endpoint) issue(challenge *protocol.Challenge, remote, local net.Addr) {
+	atomic.AddUint64(&e.stats.Challenges, 1)
+	challenge.ClientAddr = puzzle.ClientID(remote)
+	challenge.ServerID = e.serverIDs(local)[0]
+	puzzle.SignChallenge(e.identityKey, challenge)
+	e.metrics.issued.issued(challenge.ClientAddr, challenge.Nonce)
+}
+
+// nextRequest reads a request within timeout, but not after deadline; on failure it reports the error to the client if appropriate and returns false
+func (e *endpoint) nextRequest(conn net.Conn, timeout time.Duration, deadline time.Time) (any, bool) {
+	req, err := e.readRequest(conn, timeout, deadline)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		e.logf(LogWarn, "(%v) request was not received in %v", conn.RemoteAddr(), timeout)
+		atomic.AddUint64(&e.stats.RequestTimeouts, 1)
+		return nil, false
+	}
+	if errors.Is(err, puzzle.ErrRequestTooLarge) {
+		e.logf(LogWarn, "(%v) no complete request in %v bytes", conn.RemoteAddr(), e.maxRequestBytes)
+		atomic.AddUint64(&e.stats.RequestsTooLarge, 1)
+		return nil, false
+	}
+	if err != nil {
+		e.logf(LogWarn, "(%v) error processing request: %v", conn.RemoteAddr(), err)
+		if errors.Is(err, puzzle.ErrMalformedRequest) {
+			e.strike(conn)
+		}
+		e.writeResponse(conn, []byte("send hello request to begin client puzzle"))
+		return nil, false
+	}
+	return req, true
+}
+
+// redeem serves the resource if the solution is valid, see verify, and reports whether it is
+func (e *endpoint) redeem(conn net.Conn, a admission, req protocol.QuoteRequest) bool {
+	e.logf(LogInfo, "(%v) quote request", conn.RemoteAddr())
+	if err := e.verify(conn, a, req); err != nil {
+		e.writeResponse(conn, protocol.InvalidSolution)
+		return false
+	}
+	e.serveResource(conn)
+	return true
+}
+
+// verify checks the solution against the current server nonce, or the previous one so that solving across a nonce change does not fail,
+// and the lowest complexity the client may have been asked for with that nonce. Invalid and replayed solutions strike the client
+func (e *endpoint) verify(conn net.Conn, a admission, req protocol.QuoteRequest) error {
+	challenge := protocol.Challenge{
+		Nonce:      e.nonces.Current(),
+		Complexity: a.minComplexity(req.NonceServer),
+	}
+	if previous := e.nonces.Previous(); previous != 0 && req.NonceServer == previous {
+		challenge.Nonce = previous
+	}
+
+	if err := puzzle.SolutionValidFor(challenge, e.serverIDs(conn.LocalAddr()), conn.RemoteAddr(), req); err != nil {
+		e.logf(LogWarn, "(%v) invalid solution: %v", conn.RemoteAddr(), err)
+		e.countRejected(err)
+		if errors.Is(err, puzzle.ErrInvalidHash) || errors.Is(err, puzzle.ErrReplay) {
+			e.strike(conn)
+		}
+		return err
+	}
+	e.logf(LogInfo, "(%v) solution correct %v", conn.RemoteAddr(), puzzle.Hash(&req.HashData))
+	e.countAccepted(req)
+	return nil
+}
+
+// readRequest reads the request under the timeout and restores the connection deadline afterwards
+func (s *Server) readRequest(conn net.Conn, timeout time.Duration, deadline time.Time) (any, error) {
+	if requestDeadline := time.Now().Add(timeout); requestDeadline.Before(deadline) {
+		if err := conn.SetReadDeadline(requestDeadline); err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = conn.SetReadDeadline(deadline)
+		}()
+	}
+	if rc, ok := conn.(*readerConn); ok {
+		return puzzle.ReadRequestMax(rc.r, s.maxRequestBytes)
+	}
+	return puzzle.ReadRequestMax(conn, s.maxRequestBytes)
+}
+
+// readerConn reads through the buffer requests are read with, so that a handler gets what the client sent after them
+type readerConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *readerConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite keeps half-closing available to handlers like Proxy
+func (c *readerConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("connection does not support closing for writing")
+}
+
+func (e *endpoint) serveResource(conn net.Conn) {
+	if err := e.serveHandler(conn); err != nil {
+		e.logf(LogError, "(%v) error serving resource: %v", conn.RemoteAddr(), err)
+		return
+	}
+	atomic.AddUint64(&e.stats.Served, 1)
+}
+
+func (s *Server) writeResponse(conn net.Conn, bs []byte) {
+	s.logf(LogInfo, "(%v) writing: %s", conn.RemoteAddr(), bs)
+	if _, err := conn.Write(bs); err != nil {
+		s.logf(LogError, "error writing response: %v", err)
+	}
+}
@@ -0,0 +1,653 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"powquote/internal/acl"
+	"powquote/internal/ban"
+	"powquote/internal/client"
+	"powquote/internal/mtls"
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+	"powquote/internal/ratelimit"
+	"powquote/internal/tlsutil"
+	"powquote/internal/tlsutil/tlstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var discardLogger = log.New(io.Discard, "", 0)
+
+// startServer runs serve with a new server with opts until the test ends; serve is one of the Serve methods on a listener of the test
+func startServer(t *testing.T, serve func(context.Context, *Server) error, opts ...Option) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := New("", append([]Option{WithLogger(discardLogger)}, opts...)...)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		_ = serve(ctx, srv)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+	return srv
+}
+
+func start(t *testing.T, opts ...Option) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	startServer(t, func(ctx context.Context, srv *Server) error {
+		go func() {
+			<-ctx.Done()
+			_ = ln.Close()
+		}()
+		return srv.Serve(ln)
+	}, opts...)
+	return ln.Addr().String()
+}
+
+var fixedHandler = HandlerFunc(func(conn net.Conn) error {
+	_, err := conn.Write([]byte("resource"))
+	return err
+})
+
+func TestServer_Protected(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	addr := start(t, WithComplexity(2), WithHandler(fixedHandler), WithIdentityKey(key))
+
+	c := client.New(addr)
+	c.ServerKey = key.Public().(ed25519.PublicKey)
+	q, err := c.FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "resource", q.Text)
+	assert.Equal(t, 2, q.Challenge.Complexity)
+	assert.Equal(t, "127.0.0.1", q.Challenge.ClientAddr)
+
+	assert.Equal(t, puzzle.KeyFingerprint(c.ServerKey), q.Challenge.ServerID)
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	c.ServerKey = otherPub
+	_, err = c.FetchQuote(context.Background())
+	assert.ErrorIs(t, err, puzzle.ErrBadSignature)
+}
+
+func TestServer_Unprotected(t *testing.T) {
+	addr := start(t, WithProtection(false), WithHandler(fixedHandler))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+
+	bs, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "resource", string(bs))
+}
+
+func TestServer_ListenAndServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- New("127.0.0.1:0", WithLogger(discardLogger)).ListenAndServe(ctx)
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server did not stop")
+	}
+}
+
+func TestServer_Drain(t *testing.T) {
+	release := make(chan struct{})
+	slowHandler := HandlerFunc(func(conn net.Conn) error {
+		<-release
+		_, err := conn.Write([]byte("resource"))
+		return err
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := New("", WithLogger(discardLogger), WithProtection(false), WithHandler(slowHandler), WithDrainTimeout(time.Second))
+	done := make(chan error)
+	go func() {
+		done <- srv.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		return srv.Stats().Connections == 1
+	}, time.Second, time.Millisecond*10)
+
+	require.NoError(t, ln.Close())
+	close(release)
+
+	bs, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "resource", string(bs))
+	assert.ErrorIs(t, <-done, net.ErrClosed)
+	assert.Equal(t, uint64(1), srv.Stats().Served)
+}
+
+func TestServer_DrainTimeout(t *testing.T) {
+	stuckHandler := HandlerFunc(func(conn net.Conn) error {
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := New("", WithLogger(discardLogger), WithProtection(false), WithHandler(stuckHandler), WithDrainTimeout(time.Millisecond*50))
+	done := make(chan error)
+	go func() {
+		done <- srv.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		return srv.Stats().Connections == 1
+	}, time.Second, time.Millisecond*10)
+
+	require.NoError(t, ln.Close())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("server did not stop after drain timeout")
+	}
+	assert.Equal(t, uint64(0), srv.Stats().Served)
+}
+
+func TestServer_MaxConnections(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slowHandler := HandlerFunc(func(conn net.Conn) error {
+		<-release
+		return nil
+	})
+	addr := start(t, WithProtection(false), WithHandler(slowHandler), WithMaxConnections(1))
+
+	first, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer second.Close()
+	require.NoError(t, second.SetDeadline(time.Now().Add(time.Second)))
+
+	bs, err := io.ReadAll(second)
+	require.NoError(t, err)
+	assert.Equal(t, protocol.Overloaded, bs)
+}
+
+func TestServer_MaxConnectionsUnlimited(t *testing.T) {
+	addr := start(t, WithProtection(false), WithHandler(fixedHandler), WithMaxConnections(0))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+
+	bs, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "resource", string(bs))
+}
+
+// flakyListener fails a few times before being closed
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, errors.New("too many open files")
+	}
+	return nil, net.ErrClosed
+}
+
+func TestServer_AcceptBackoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	started := time.Now()
+	err = New("", WithLogger(discardLogger)).Serve(&flakyListener{Listener: ln, failures: 3})
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.GreaterOrEqual(t, time.Since(started), minAcceptBackoff*(1+2+4))
+}
+
+func TestNextBackoff(t *testing.T) {
+	assert.Equal(t, minAcceptBackoff, nextBackoff(0))
+	assert.Equal(t, minAcceptBackoff*2, nextBackoff(minAcceptBackoff))
+	assert.Equal(t, maxAcceptBackoff, nextBackoff(maxAcceptBackoff))
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	limiter := ratelimit.NewClientLimiter(ratelimit.Config{
+		PerIP: ratelimit.Rate{PerSecond: 0.001, Burst: 1},
+	})
+	addr := start(t, WithProtection(false), WithHandler(fixedHandler), WithRateLimiter(limiter))
+
+	read := func() string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		bs, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(bs)
+	}
+
+	assert.Equal(t, "resource", read())
+	assert.Equal(t, string(protocol.RateLimited), read())
+}
+
+func TestServer_SlowRequest(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	srv := New("", WithLogger(discardLogger), WithRequestTimeout(time.Millisecond*50))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+
+	_, err = conn.Write([]byte("\n\nHEL"))
+	require.NoError(t, err)
+
+	bs, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, bs)
+	assert.Equal(t, uint64(1), srv.Stats().RequestTimeouts)
+}
+
+func TestServer_RequestTooLarge(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	srv := New("", WithLogger(discardLogger), WithMaxRequestBytes(16))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+
+	_, err = conn.Write(bytes.Repeat([]byte("\n"), 32))
+	require.NoError(t, err)
+
+	// the server closes the connection with unread data, so it may be reset
+	bs, _ := io.ReadAll(conn)
+	assert.Empty(t, bs)
+	assert.Equal(t, uint64(1), srv.Stats().RequestsTooLarge)
+}
+
+func TestServer_ACL(t *testing.T) {
+	read := func(addr string) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		bs, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(bs)
+	}
+
+	allow, err := acl.Parse(strings.NewReader("allow 127.0.0.0/8"))
+	require.NoError(t, err)
+	assert.Equal(t, "resource", read(start(t, WithHandler(fixedHandler), WithACL(allow))), "puzzle is skipped")
+
+	deny, err := acl.Parse(strings.NewReader("deny 127.0.0.1"))
+	require.NoError(t, err)
+	assert.Empty(t, read(start(t, WithProtection(false), WithHandler(fixedHandler), WithACL(deny))))
+}
+
+func TestServer_Bans(t *testing.T) {
+	bans := ban.NewManager(ban.Policy{Strikes: 2, Window: time.Minute, Duration: time.Minute})
+	addr := start(t, WithComplexity(40), WithBans(bans))
+
+	say := func(what string) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		_, err = conn.Write([]byte(what + "\n"))
+		require.NoError(t, err)
+		// banned connections are closed with unread data, so they may be reset
+		bs, _ := io.ReadAll(conn)
+		return string(bs)
+	}
+
+	challenge, err := protocol.ChallengeFromBytes([]byte(say(string(protocol.Hello))))
+	require.NoError(t, err)
+
+	garbage := protocol.QuoteRequest{ServerID: addr, HashData: protocol.HashData{ClientID: "127.0.0.1", NonceServer: challenge.Nonce}}
+	for i := 0; i < 2; i++ {
+		garbage.NonceClient = uint64(i)
+		assert.Equal(t, string(protocol.InvalidSolution), say(string(garbage.Bytes())))
+	}
+
+	assert.Empty(t, say(string(protocol.Hello)), "client is banned")
+	assert.Len(t, bans.List(), 1)
+}
+
+func TestServer_DeniedClientsTakeNoSlots(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slowHandler := HandlerFunc(func(conn net.Conn) error {
+		<-release
+		return nil
+	})
+	deny, err := acl.Parse(strings.NewReader("deny 127.0.0.2"))
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	srv := New("", WithLogger(discardLogger), WithProtection(false), WithHandler(slowHandler), WithMaxConnections(1), WithACL(deny))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	require.Eventually(t, func() bool { return srv.Stats().Connections == 1 }, time.Second, time.Millisecond*10)
+
+	denied := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}, Timeout: time.Second}
+	for i := 0; i < 3; i++ {
+		conn, err := denied.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		bs, _ := io.ReadAll(conn)
+		assert.Empty(t, bs, "denied clients are closed without a response")
+		_ = conn.Close()
+	}
+
+	stats := srv.Stats()
+	assert.Equal(t, uint64(3), stats.Denied)
+	assert.Equal(t, uint64(0), stats.Overloaded, "denied clients are turned away before taking a slot")
+}
+
+func TestServer_BansMalformedRequests(t *testing.T) {
+	bans := ban.NewManager(ban.Policy{Strikes: 2, Window: time.Minute, Duration: time.Minute})
+	addr := start(t, WithComplexity(40), WithBans(bans))
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		_, err = conn.Write([]byte("not--a--quote--request\n"))
+		require.NoError(t, err)
+		_, _ = io.ReadAll(conn)
+		_ = conn.Close()
+	}
+
+	assert.Len(t, bans.List(), 1)
+}
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	addr := start(t, WithComplexity(1), WithHandler(fixedHandler), WithProxyProtocol(ProxyProtocol{Trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}))
+
+	const header = "PROXY TCP4 203.0.113.7 192.0.2.1 5555 9999\r\n"
+	sayWith := func(header string, what []byte) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		_, err = conn.Write(append(append([]byte(header), what...), '\n'))
+		require.NoError(t, err)
+		bs, _ := io.ReadAll(conn)
+		return string(bs)
+	}
+	say := func(what []byte) string {
+		return sayWith(header, what)
+	}
+
+	challenge, err := protocol.ChallengeFromBytes([]byte(say(protocol.Hello)))
+	require.NoError(t, err)
+
+	req := protocol.QuoteRequest{
+		ServerID: "192.0.2.1:9999",
+		HashData: protocol.HashData{
+			ClientID:    "203.0.113.7",
+			NonceServer: challenge.Nonce,
+			NonceClient: 1,
+		},
+	}
+	puzzle.Solve(&req.HashData, challenge)
+	assert.Equal(t, "resource", say(req.Bytes()), "addresses from the header are used for the puzzle")
+
+	assert.Empty(t, sayWith("", protocol.Hello), "connection without a header is closed")
+}
+
+func TestServer_ProxyProtocolUntrusted(t *testing.T) {
+	allow, err := acl.Parse(strings.NewReader("allow 203.0.113.7"))
+	require.NoError(t, err)
+	// no peer is trusted without a list
+	for _, trusted := range [][]netip.Prefix{{netip.MustParsePrefix("10.0.0.0/8")}, nil} {
+		addr := start(t, WithComplexity(40), WithHandler(fixedHandler), WithACL(allow),
+			WithProxyProtocol(ProxyProtocol{Optional: true, Trusted: trusted}))
+
+		bs := say(t, addr, []byte("PROXY TCP4 203.0.113.7 192.0.2.1 5555 9999\r\nHELLO"))
+		assert.NotEqual(t, "resource", bs, "a spoofed header from an untrusted peer does not pass the allowlist")
+	}
+}
+
+func TestServer_ServerIDs(t *testing.T) {
+	addr := start(t, WithComplexity(1), WithHandler(fixedHandler), WithServerIDs("quotes.example.com", "quotes.internal"))
+
+	say := func(what []byte) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		_, err = conn.Write(append(what, '\n'))
+		require.NoError(t, err)
+		bs, _ := io.ReadAll(conn)
+		return string(bs)
+	}
+
+	challenge, err := protocol.ChallengeFromBytes([]byte(say(protocol.Hello)))
+	require.NoError(t, err)
+	assert.Equal(t, "quotes.example.com", challenge.ServerID)
+
+	for i, serverID := range []string{"quotes.internal", addr} {
+		req := protocol.QuoteRequest{
+			ServerID: serverID,
+			HashData: protocol.HashData{
+				ClientID:    "127.0.0.1",
+				NonceServer: challenge.Nonce,
+				NonceClient: uint64(i),
+			},
+		}
+		puzzle.Solve(&req.HashData, challenge)
+		want := "resource"
+		if serverID == addr {
+			want = string(protocol.InvalidSolution)
+		}
+		assert.Equal(t, want, say(req.Bytes()), serverID)
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlstest.NewCA(t)
+	certFile, keyFile := tlstest.WriteCert(t, dir, "server", ca.Issue(t, "server", "127.0.0.1", "quotes.example.com"))
+	certs, err := tlsutil.NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	addr := start(t, WithComplexity(1), WithHandler(fixedHandler), WithTLS(tlsutil.ServerConfig(certs, nil)))
+
+	c := client.New(addr)
+	c.TLS = &tls.Config{RootCAs: ca.Pool()}
+	q, err := c.FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "resource", q.Text)
+
+	c.TLS = &tls.Config{RootCAs: ca.Pool(), ServerName: "quotes.example.com"}
+	_, err = c.FetchQuote(context.Background())
+	assert.NoError(t, err)
+
+	c.TLS = &tls.Config{RootCAs: ca.Pool(), ServerName: "other.example.com"}
+	_, err = c.FetchQuote(context.Background())
+	assert.Error(t, err, "server name must match")
+
+	plain := client.New(addr)
+	plain.IOTimeout = time.Millisecond * 200
+	_, err = plain.FetchQuote(context.Background())
+	assert.Error(t, err, "plaintext client is not served")
+}
+
+func TestServer_ClientPolicies(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlstest.NewCA(t)
+	certFile, keyFile := tlstest.WriteCert(t, dir, "server", ca.Issue(t, "server", "127.0.0.1"))
+	certs, err := tlsutil.NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	policies, err := mtls.Parse(strings.NewReader(`[
+		{"common_name": "exempt", "exempt": true},
+		{"common_name": "easy", "complexity": 1},
+		{"common_name": "quota", "rate": 0.001, "burst": 1}
+	]`))
+	require.NoError(t, err)
+
+	addr := start(t,
+		WithComplexity(6),
+		WithHandler(fixedHandler),
+		WithTLS(tlsutil.ServerConfig(certs, ca.Pool())),
+		WithClientPolicies(policies),
+		WithRateLimiter(ratelimit.NewClientLimiter(ratelimit.Config{PerIP: ratelimit.Rate{PerSecond: 1000, Burst: 1000}})),
+	)
+
+	say := func(cfg *tls.Config) string {
+		conn, err := tls.Dial("tcp", addr, cfg)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		_, err = conn.Write(append(protocol.Hello, '\n'))
+		require.NoError(t, err)
+		bs, _ := io.ReadAll(conn)
+		return string(bs)
+	}
+	withCert := func(cn string) *tls.Config {
+		return &tls.Config{RootCAs: ca.Pool(), Certificates: []tls.Certificate{ca.Issue(t, cn)}}
+	}
+	complexity := func(response string) int {
+		challenge, err := protocol.ChallengeFromBytes([]byte(response))
+		require.NoError(t, err, response)
+		return challenge.Complexity
+	}
+
+	assert.Equal(t, "resource", say(withCert("exempt")))
+	assert.Equal(t, 1, complexity(say(withCert("easy"))))
+	assert.Equal(t, 6, complexity(say(withCert("stranger"))))
+	assert.Equal(t, 6, complexity(say(&tls.Config{RootCAs: ca.Pool()})), "clients without certificates are served as usual")
+
+	assert.Equal(t, 6, complexity(say(withCert("quota"))))
+	assert.Equal(t, string(protocol.RateLimited), say(withCert("quota")), "quota replaces the IP rate limit")
+
+	c := client.New(addr)
+	c.TLS = withCert("easy")
+	q, err := c.FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "resource", q.Text, "solution is checked against the reduced complexity")
+
+	other := tlstest.NewCA(t)
+	c.TLS = &tls.Config{RootCAs: ca.Pool(), Certificates: []tls.Certificate{other.Issue(t, "exempt")}}
+	_, err = c.FetchQuote(context.Background())
+	assert.Error(t, err, "certificates from unknown CAs are refused")
+}
+
+func TestServer_SolutionAcrossNonceChange(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	srv := New("", WithLogger(discardLogger), WithComplexity(1), WithHandler(fixedHandler), WithNoncePeriod(time.Millisecond*200))
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	challenge, err := protocol.ChallengeFromBytes([]byte(say(t, ln.Addr().String(), protocol.Hello)))
+	require.NoError(t, err)
+	// the nonce changes once while solving
+	time.Sleep(time.Millisecond * 300)
+	require.NotEqual(t, challenge.Nonce, srv.nonces.Current())
+
+	req := protocol.QuoteRequest{
+		ServerID: challenge.ServerID,
+		HashData: protocol.HashData{ClientID: challenge.ClientAddr, NonceServer: challenge.Nonce, NonceClient: 1},
+	}
+	puzzle.Solve(&req.HashData, challenge)
+	assert.Equal(t, "resource", say(t, ln.Addr().String(), req.Bytes()), "solution for the previous nonce")
+}
+
+func TestServer_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "powquote.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := New("unix:"+path, WithLogger(discardLogger), WithComplexity(2), WithHandler(fixedHandler))
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.ListenAndServe(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+
+	c := client.New(path)
+	c.Network = "unix"
+	q, err := c.FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "resource", q.Text)
+	assert.Equal(t, puzzle.LocalClientID, q.Challenge.ClientAddr)
+
+	_, err = c.FetchQuote(context.Background())
+	assert.NoError(t, err, "local clients are not rejected as replays")
+
+	cancel()
+	assert.NoError(t, <-served)
+}
+
+// say sends a line on a new connection to addr and returns everything the server writes back
+func say(t *testing.T, addr string, line []byte) string {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Write(append(line, '\n'))
+	require.NoError(t, err)
+	bs, _ := io.ReadAll(conn)
+	return string(bs)
+}
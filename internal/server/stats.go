@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the server counters
+type Stats struct {
+	Connections uint64
+	Challenges  uint64
+	Accepted    uint64
+	Rejected    uint64
+	// Served counts resources served, either after a valid solution or without protection
+	Served uint64
+	// Overloaded counts connections rejected because of the concurrent connections limit
+	Overloaded uint64
+	// RateLimited counts connections rejected by the rate limiter
+	RateLimited uint64
+	// RequestTimeouts and RequestsTooLarge count connections closed because the request line was not received in time or in size
+	RequestTimeouts  uint64
+	RequestsTooLarge uint64
+	// Denied counts connections from denylisted clients
+	Denied uint64
+	// Banned counts connections from temporarily banned clients
+	Banned uint64
+	// HandshakeErrors counts connections closed because of a broken PROXY protocol header or TLS handshake
+	HandshakeErrors uint64
+	// Certified counts connections with a verified client certificate matching a client policy
+	Certified uint64
+	// Dropped counts UDP requests left without a response that would be larger than the request
+	Dropped uint64
+	// BytesIn and BytesOut count the traffic with clients
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// counters are updated atomically while the server is running
+type counters Stats
+
+func (c *counters) snapshot() Stats {
+	return Stats{
+		Connections:      atomic.LoadUint64(&c.Connections),
+		Challenges:       atomic.LoadUint64(&c.Challenges),
+		Accepted:         atomic.LoadUint64(&c.Accepted),
+		Rejected:         atomic.LoadUint64(&c.Rejected),
+		Served:           atomic.LoadUint64(&c.Served),
+		Overloaded:       atomic.LoadUint64(&c.Overloaded),
+		RateLimited:      atomic.LoadUint64(&c.RateLimited),
+		RequestTimeouts:  atomic.LoadUint64(&c.RequestTimeouts),
+		RequestsTooLarge: atomic.LoadUint64(&c.RequestsTooLarge),
+		Denied:           atomic.LoadUint64(&c.Denied),
+		Banned:           atomic.LoadUint64(&c.Banned),
+		HandshakeErrors:  atomic.LoadUint64(&c.HandshakeErrors),
+		Certified:        atomic.LoadUint64(&c.Certified),
+		Dropped:          atomic.LoadUint64(&c.Dropped),
+		BytesIn:          atomic.LoadUint64(&c.BytesIn),
+		BytesOut:         atomic.LoadUint64(&c.BytesOut),
+	}
+}
+
+func (s Stats) add(o Stats) Stats {
+	return Stats{
+		Connections:      s.Connections + o.Connections,
+		Challenges:       s.Challenges + o.Challenges,
+		Accepted:         s.Accepted + o.Accepted,
+		Rejected:         s.Rejected + o.Rejected,
+		Served:           s.Served + o.Served,
+		Overloaded:       s.Overloaded + o.Overloaded,
+		RateLimited:      s.RateLimited + o.RateLimited,
+		RequestTimeouts:  s.RequestTimeouts + o.RequestTimeouts,
+		RequestsTooLarge: s.RequestsTooLarge + o.RequestsTooLarge,
+		Denied:           s.Denied + o.Denied,
+		Banned:           s.Banned + o.Banned,
+		HandshakeErrors:  s.HandshakeErrors + o.HandshakeErrors,
+		Certified:        s.Certified + o.Certified,
+		Dropped:          s.Dropped + o.Dropped,
+		BytesIn:          s.BytesIn + o.BytesIn,
+		BytesOut:         s.BytesOut + o.BytesOut,
+	}
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("connections = %v, challenges = %v, solutions accepted = %v, rejected = %v, served = %v, overloaded = %v, rate limited = %v, request timeouts = %v, requests too large = %v, denied = %v, banned = %v, handshake errors = %v, certified = %v, dropped = %v, bytes in = %v, bytes out = %v",
+		s.Connections, s.Challenges, s.Accepted, s.Rejected, s.Served, s.Overloaded, s.RateLimited, s.RequestTimeouts, s.RequestsTooLarge, s.Denied, s.Banned, s.HandshakeErrors, s.Certified, s.Dropped, s.BytesIn, s.BytesOut)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+)
+
+// ListenAndServeUDP listens on addr and serves datagrams until ctx is done, see ServeUDP
+func (s *Server) ListenAndServeUDP(ctx context.Context, addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeUDP(ctx, pc)
+}
+
+// ServeUDP runs the puzzle over datagrams on pc until ctx is done, one request per datagram.
+// Nothing is stored per client between the challenge and the solution.
+// Until a solution is verified no response is larger than the request, see protocol.HelloDatagramSize,
+// and resources that do not fit in protocol.MaxDatagramSize are refused.
+// The source address of a datagram may be spoofed, so invalid solutions do not count towards bans
+// and only verified solutions take rate limit tokens. Handlers run on the read loop and must not block,
+// so the single connection flow is refused, see WithSingleConnection
+func (s *Server) ServeUDP(ctx context.Context, pc net.PacketConn) error {
+	return s.newEndpoint(endpointName("udp", pc.LocalAddr()), nil).serveUDP(ctx, pc)
+}
+
+func (e *endpoint) serveUDP(ctx context.Context, pc net.PacketConn) error {
+	if e.singleConnection {
+		return errors.New("the single connection flow cannot be served over UDP")
+	}
+	if size := len(e.largestChallenge(pc.LocalAddr()).Bytes()); size > protocol.HelloDatagramSize {
+		return fmt.Errorf("challenges with server id %q take up to %v bytes, more than the %v bytes of a request datagram", e.serverIDs(pc.LocalAddr())[0], size, protocol.HelloDatagramSize)
+	}
+	defer e.start()()
+	go func() {
+		<-ctx.Done()
+		_ = pc.Close()
+	}()
+
+	e.logger.Printf("begin serving datagrams on %v; DoS protected = %v, complexity = %v", pc.LocalAddr(), e.settings().protected, e.settings().complexity)
+	buf := make([]byte, 64*1024)
+	var backoff time.Duration
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			backoff = nextBackoff(backoff)
+			e.logf(LogError, "error reading datagram: %v; retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		// verifying a solution is a single hash and handlers do not block, so datagrams are handled in order without spawning goroutines
+		e.handleDatagram(pc, addr, buf[:n])
+	}
+}
+
+// largestChallenge is a challenge with the longest values the endpoint may send, unverified responses are not allowed to exceed the request size
+func (e *endpoint) largestChallenge(local net.Addr) protocol.Challenge {
+	return protocol.Challenge{
+		Nonce:      math.MaxUint64,
+		Complexity: math.MaxInt,
+		ClientAddr: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+		ServerID:   e.serverIDs(local)[0],
+		Signature:  make([]byte, ed25519.SignatureSize),
+	}
+}
+
+func (e *endpoint) handleDatagram(pc net.PacketConn, addr net.Addr, datagram []byte) {
+	remote, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return
+	}
+	remote = netip.AddrPortFrom(remote.Addr().Unmap(), remote.Port())
+	atomic.AddUint64(&e.stats.Connections, 1)
+	atomic.AddUint64(&e.stats.BytesIn, uint64(len(datagram)))
+
+	reply := func(response []byte, verified bool) {
+		if !verified && len(response) > len(datagram) {
+			atomic.AddUint64(&e.stats.Dropped, 1)
+			e.logf(LogWarn, "(%v) dropping %v bytes response to %v bytes request", addr, len(response), len(datagram))
+			return
+		}
+		if len(response) > protocol.MaxDatagramSize {
+			e.logf(LogWarn, "(%v) %v bytes response does not fit in a datagram", addr, len(response))
+			response = protocol.ResponseTooLarge
+		}
+		n, err := pc.WriteTo(response, addr)
+		atomic.AddUint64(&e.stats.BytesOut, uint64(n))
+		if err != nil {
+			e.logf(LogError, "(%v) error writing datagram: %v", addr, err)
+		}
+	}
+
+	// rate limits are applied once the solution is verified, so spoofed datagrams cannot use up the tokens of another client
+	a, refused := e.admitClient(addr.String(), remote.Addr(), true, nil, false)
+	switch refused {
+	case refusedDenied, refusedBanned:
+		return
+	case refusedRateLimited:
+		if !e.silentRejects {
+			reply(protocol.RateLimited, false)
+		}
+		return
+	}
+
+	conn := &bufferConn{local: pc.LocalAddr(), remote: net.UDPAddrFromAddrPort(remote), unverified: true}
+	req, err := puzzle.ReadRequestMax(bytes.NewReader(datagram), e.maxRequestBytes)
+	if errors.Is(err, puzzle.ErrRequestTooLarge) {
+		atomic.AddUint64(&e.stats.RequestsTooLarge, 1)
+		return
+	}
+	if err != nil {
+		e.logf(LogWarn, "(%v) error processing request: %v", addr, err)
+		return
+	}
+
+	if a.exempt {
+		// the source address of a datagram may be spoofed, so the size rule still applies
+		e.serveResource(conn)
+		reply(conn.buf.Bytes(), false)
+		return
+	}
+
+	switch req := req.(type) {
+	case protocol.ChallengeRequest:
+		challenge := protocol.Challenge{
+			Nonce:      e.nonces.Current(),
+			Complexity: a.complexity,
+		}
+		e.logf(LogInfo, "(%v) datagram challenge request", addr)
+		e.issue(&challenge, conn.remote, conn.local)
+		reply(challenge.Bytes(), false)
+	case protocol.QuoteRequest:
+		e.logf(LogInfo, "(%v) datagram quote request", addr)
+		if err := e.verify(conn, a, req); err != nil {
+			reply(protocol.InvalidSolution, false)
+			return
+		}
+		if e.rateLimited(e.settings(), addr.String(), remote.Addr()) {
+			if !e.silentRejects {
+				reply(protocol.RateLimited, true)
+			}
+			return
+		}
+		e.serveResource(conn)
+		reply(conn.buf.Bytes(), true)
+	}
+}
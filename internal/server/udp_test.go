@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"powquote/internal/ban"
+	"powquote/internal/client"
+	"powquote/internal/protocol"
+	"powquote/internal/puzzle"
+	"powquote/internal/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startUDP(t *testing.T, opts ...Option) (*Server, string) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := startServer(t, func(ctx context.Context, srv *Server) error {
+		return srv.ServeUDP(ctx, pc)
+	}, opts...)
+	return srv, pc.LocalAddr().String()
+}
+
+func udpClient(addr string) *client.Client {
+	c := client.New(addr)
+	c.Network = "udp"
+	c.IOTimeout = time.Second
+	return c
+}
+
+func TestServer_UDP(t *testing.T) {
+	_, addr := startUDP(t, WithComplexity(2), WithHandler(fixedHandler))
+
+	q, err := udpClient(addr).FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "resource", q.Text)
+	assert.Equal(t, 2, q.Challenge.Complexity)
+}
+
+func TestServer_UDPAmplification(t *testing.T) {
+	srv, addr := startUDP(t, WithComplexity(2), WithHandler(fixedHandler))
+
+	conn, err := net.Dial("udp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("HELLO\n"))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Millisecond*200)))
+	_, err = conn.Read(make([]byte, protocol.MaxDatagramSize))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "challenge is larger than the request")
+	assert.Equal(t, uint64(1), srv.Stats().Dropped)
+}
+
+func TestServer_UDPResponseTooLarge(t *testing.T) {
+	large := HandlerFunc(func(conn net.Conn) error {
+		_, err := conn.Write([]byte(strings.Repeat("x", protocol.MaxDatagramSize+1)))
+		return err
+	})
+	_, addr := startUDP(t, WithComplexity(1), WithHandler(large))
+
+	_, err := udpClient(addr).FetchQuote(context.Background())
+	assert.ErrorIs(t, err, client.ErrResponseTooLarge)
+}
+
+func TestServer_UDPSpoofedSolutions(t *testing.T) {
+	bans := ban.NewManager(ban.Policy{Strikes: 2, Window: time.Minute, Duration: time.Minute})
+	limiter := ratelimit.NewClientLimiter(ratelimit.Config{PerIP: ratelimit.Rate{PerSecond: 0.001, Burst: 1}})
+	srv, udpAddr := startUDP(t, WithComplexity(40), WithBans(bans), WithRateLimiter(limiter))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	// datagrams posing as the client with invalid solutions
+	conn, err := net.Dial("udp", udpAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		bad := protocol.QuoteRequest{
+			ServerID: puzzle.KeyFingerprint(srv.PublicKey()),
+			HashData: protocol.HashData{ClientID: "127.0.0.1", NonceServer: srv.nonces.Current(), NonceClient: uint64(i)},
+		}
+		_, err = conn.Write(bad.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		bs := make([]byte, protocol.MaxDatagramSize)
+		n, err := conn.Read(bs)
+		require.NoError(t, err)
+		assert.Equal(t, protocol.InvalidSolution, bs[:n])
+	}
+	assert.Empty(t, bans.List(), "unverified sources are not banned")
+
+	tcp, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer tcp.Close()
+	require.NoError(t, tcp.SetDeadline(time.Now().Add(time.Second)))
+	_, err = tcp.Write(append(protocol.Hello, '\n'))
+	require.NoError(t, err)
+	bs, err := io.ReadAll(tcp)
+	require.NoError(t, err)
+	_, err = protocol.ChallengeFromBytes(bs)
+	assert.NoError(t, err, "the client is neither banned nor rate limited on TCP")
+}
+
+func TestServer_UDPSingleConnection(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	srv := New("", WithLogger(discardLogger), WithSingleConnection())
+	assert.Error(t, srv.ServeUDP(context.Background(), pc))
+}
+
+func TestServer_UDPServerIDTooLong(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	srv := New("", WithLogger(discardLogger), WithServerIDs(strings.Repeat("x", protocol.HelloDatagramSize)))
+	assert.ErrorContains(t, srv.ServeUDP(context.Background(), pc), "bytes of a request datagram")
+
+	// the longest id that fits is served
+	size := len(srv.newEndpoint("udp", nil).largestChallenge(pc.LocalAddr()).Bytes())
+	_, addr := startUDP(t, WithComplexity(1), WithHandler(fixedHandler), WithServerIDs(strings.Repeat("x", 2*protocol.HelloDatagramSize-size)))
+	q, err := udpClient(addr).FetchQuote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "resource", q.Text)
+}
+
+// flakyPacketConn fails a few times before being closed
+type flakyPacketConn struct {
+	net.PacketConn
+	failures int
+}
+
+func (c *flakyPacketConn) ReadFrom([]byte) (int, net.Addr, error) {
+	if c.failures > 0 {
+		c.failures--
+		return 0, nil, errors.New("no buffer space available")
+	}
+	return 0, nil, net.ErrClosed
+}
+
+func TestServer_UDPReadBackoff(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	started := time.Now()
+	err = New("", WithLogger(discardLogger)).ServeUDP(context.Background(), &flakyPacketConn{PacketConn: pc, failures: 3})
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.GreaterOrEqual(t, time.Since(started), minAcceptBackoff*(1+2+4))
+}
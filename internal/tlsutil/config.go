@@ -0,0 +1,72 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ServerConfig serves the reloaded certificate. If clientCAs is not nil, clients may present a certificate issued by one of them;
+// connections with certificates that fail verification are refused, connections without one are accepted
+func ServerConfig(certs *CertReloader, clientCAs *x509.CertPool) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if clientCAs != nil {
+		cfg.ClientCAs = clientCAs
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg
+}
+
+type ClientOptions struct {
+	// CAFile is a PEM bundle of CAs to verify the server with instead of the system ones
+	CAFile string
+	// ServerName overrides the name the server certificate is verified against, the host from the address by default
+	ServerName string
+	// CertFile and KeyFile are the optional client certificate
+	CertFile string
+	KeyFile  string
+}
+
+func ClientConfig(opts ClientOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: opts.ServerName,
+	}
+
+	if opts.CAFile != "" {
+		pool, err := LoadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bs) {
+		return nil, fmt.Errorf("%v: %w", path, errNoCerts)
+	}
+	return pool, nil
+}
+
+var errNoCerts = errors.New("no PEM certificates found")
@@ -0,0 +1,43 @@
+package tlsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"powquote/internal/tlsutil/tlstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlstest.NewCA(t)
+	caFile := ca.WriteCA(t, dir)
+	certFile, keyFile := tlstest.WriteCert(t, dir, "client", ca.Issue(t, "client"))
+
+	cfg, err := ClientConfig(ClientOptions{
+		CAFile:     caFile,
+		ServerName: "quotes.example.com",
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "quotes.example.com", cfg.ServerName)
+	assert.NotNil(t, cfg.RootCAs)
+	assert.Len(t, cfg.Certificates, 1)
+
+	cfg, err = ClientConfig(ClientOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, cfg.RootCAs, "system CAs are used by default")
+	assert.Empty(t, cfg.Certificates)
+
+	notPEM := filepath.Join(dir, "not.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("hello"), 0600))
+	_, err = ClientConfig(ClientOptions{CAFile: notPEM})
+	assert.ErrorContains(t, err, "no PEM certificates found")
+
+	_, err = ClientConfig(ClientOptions{CertFile: certFile})
+	assert.Error(t, err, "key is required with a certificate")
+}
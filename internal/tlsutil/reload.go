@@ -0,0 +1,90 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadCheckPeriod limits how often certificate files are checked for changes
+const reloadCheckPeriod = time.Second
+
+// CertReloader serves a certificate from files and reloads it when the files change, e.g. after renewal
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	// OnError is called when changed files cannot be loaded, once until they change again; the previous certificate stays in use
+	OnError func(error)
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+	// modTime is of the files last loaded or failed to load, zero while they are missing
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	r.modTime = modTime
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *CertReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	return nil
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.lastCheck) >= reloadCheckPeriod {
+		r.lastCheck = now
+		modTime, err := r.filesModTime()
+		switch {
+		case err != nil && r.modTime.IsZero():
+			// reported when the files went missing
+			err = nil
+		case err != nil:
+			r.modTime = time.Time{}
+		case !modTime.Equal(r.modTime):
+			// files that fail to load are not loaded again until they change
+			r.modTime = modTime
+			err = r.load()
+		}
+		if err != nil && r.OnError != nil {
+			r.OnError(err)
+		}
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,73 @@
+package tlsutil
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"powquote/internal/tlsutil/tlstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlstest.NewCA(t)
+
+	first := ca.Issue(t, "first", "localhost")
+	certFile, keyFile := tlstest.WriteCert(t, dir, "server", first)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.Certificate, cert.Certificate)
+
+	second := ca.Issue(t, "second", "localhost")
+	tlstest.WriteCert(t, dir, "server", second)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.Certificate, cert.Certificate, "files are not checked too often")
+
+	r.lastCheck = time.Time{}
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.Certificate, cert.Certificate, "changed files are reloaded")
+
+	var reloadErrs []error
+	r.OnError = func(err error) {
+		reloadErrs = append(reloadErrs, err)
+	}
+	require.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0600))
+	evenLater := later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyFile, evenLater, evenLater))
+
+	r.lastCheck = time.Time{}
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.Certificate, cert.Certificate, "broken files do not replace a working certificate")
+	assert.Len(t, reloadErrs, 1)
+
+	r.lastCheck = time.Time{}
+	_, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Len(t, reloadErrs, 1, "broken files are reported once")
+
+	require.NoError(t, os.Remove(keyFile))
+	for i := 0; i < 2; i++ {
+		r.lastCheck = time.Time{}
+		_, err = r.GetCertificate(nil)
+		require.NoError(t, err)
+	}
+	assert.Len(t, reloadErrs, 2, "missing files are reported once")
+}
+
+func TestNewCertReloader_Missing(t *testing.T) {
+	_, err := NewCertReloader("missing.pem", "missing.key")
+	assert.Error(t, err)
+}
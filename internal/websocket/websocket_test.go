@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+// startEcho serves a websocket endpoint writing every message back
+func startEcho(t *testing.T) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.MaxMessageSize = 1000
+		for {
+			msgType, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(msgType, msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func dial(t *testing.T, addr string) *Conn {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	ws, err := Dial(conn, "ws://"+addr+"/")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ws.Close()
+	})
+	return ws
+}
+
+func TestConn(t *testing.T) {
+	ws := dial(t, startEcho(t))
+
+	for _, size := range []int{0, 5, 125, 126, 1000} {
+		msg := bytes.Repeat([]byte("x"), size)
+		require.NoError(t, ws.WriteMessage(BinaryMessage, msg))
+		msgType, got, err := ws.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, BinaryMessage, msgType)
+		assert.Equal(t, string(msg), string(got), size)
+	}
+
+	require.NoError(t, ws.writeFrame(opPing, []byte("ping")))
+	require.NoError(t, ws.WriteMessage(TextMessage, []byte("after ping")))
+	msgType, got, err := ws.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, msgType, "pong is skipped")
+	assert.Equal(t, "after ping", string(got))
+}
+
+func TestConn_Fragmented(t *testing.T) {
+	ws := dial(t, startEcho(t))
+
+	// a text message in two frames, the first one without FIN
+	frames := [][]byte{
+		{0x01, 0x80 | 3, 0, 0, 0, 0, 'f', 'o', 'o'},
+		{0x80, 0x80 | 3, 0, 0, 0, 0, 'b', 'a', 'r'},
+	}
+	for _, f := range frames {
+		_, err := ws.conn.Write(f)
+		require.NoError(t, err)
+	}
+	_, got, err := ws.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "foobar", string(got))
+}
+
+func TestConn_TooLarge(t *testing.T) {
+	ws := dial(t, startEcho(t))
+
+	require.NoError(t, ws.WriteMessage(BinaryMessage, bytes.Repeat([]byte("x"), 1001)))
+	_, _, err := ws.ReadMessage()
+	assert.ErrorIs(t, err, io.EOF, "server closes the connection")
+}
+
+func TestUpgrade_BadHandshake(t *testing.T) {
+	addr := startEcho(t)
+	resp, err := http.Get("http://" + addr)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}